@@ -1,10 +1,13 @@
 package winminer
 
 import (
+	"context"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 )
 
 // An APIClient is a client for the WinMiner API.
@@ -15,41 +18,120 @@ type APIClient struct {
 
 	ws     *WebsocketClient
 	wsLock sync.Mutex
+	resume *resumeState
+
+	miningToken  string
+	balanceToken string
 }
 
 // NewAPIClient constructs a new API client and attempts to log in.
-func NewAPIClient(email, password string, debug bool) (*APIClient, error) {
-	c := &lowLevelClient{
-		c:             &http.Client{},
-		debug:         debug,
-		userTokenLock: sync.RWMutex{},
-	}
+func NewAPIClient(email, password string, debug bool, opts ...Option) (*APIClient, error) {
+	c := newAPIClient(email, password, debug, opts)
 
-	_, err := c.postLogin(email, password)
+	_, err := c.c.postLogin(email, password)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to login")
 	}
 
-	return &APIClient{
-		c:        c,
+	return c, nil
+}
+
+// NewAPIClientWithToken constructs a new API client that uses the given user
+// token, as returned by UserToken, instead of logging in.
+// The token is validated with one authenticated request.
+// Returns ErrInvalidCredentials if the server rejects it.
+// Since the client has no credentials, UpdateLoginToken can not be used.
+func NewAPIClientWithToken(token string, debug bool, opts ...Option) (*APIClient, error) {
+	c := newAPIClient("", "", debug, opts)
+	c.c.userToken = token
+
+	_, err := c.c.getMachines(context.Background(), true)
+	if err != nil {
+		if isAuthError(err) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, errors.Wrap(err, "unable to validate token")
+	}
+
+	return c, nil
+}
+
+func newAPIClient(email, password string, debug bool, opts []Option) *APIClient {
+	c := &APIClient{
+		c: &lowLevelClient{
+			c:                 &http.Client{},
+			apiBaseURL:        defaultAPIBaseURL,
+			debug:             debug,
+			userTokenLock:     sync.RWMutex{},
+			wsCompression:     true,
+			pingInterval:      defaultPingInterval,
+			keepAliveInterval: defaultKeepAliveInterval,
+			maxFrameSize:      defaultMaxFrameSize,
+			responseSizeLimit: defaultResponseSizeLimit,
+			hubName:           defaultHubName,
+			keepAliveMethod:   defaultKeepAliveMethod,
+			now:               time.Now,
+			logger:            newLogger(debug),
+		},
 		email:    email,
 		password: password,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.c.applyTLSConfig()
+
+	return c
+}
+
+// UserToken returns the current user token, which can be persisted and used
+// with NewAPIClientWithToken to avoid logging in again.
+// Treat it like a password.
+func (c *APIClient) UserToken() string {
+	c.c.userTokenLock.RLock()
+	defer c.c.userTokenLock.RUnlock()
+
+	return c.c.userToken
+}
+
+// newLogger returns the logger of a new client.
+// Every client logs to its own logger, so that the configuration of the
+// global logger is neither used nor modified.
+// Only warnings and errors are logged, unless debug is set.
+func newLogger(debug bool) *log.Logger {
+	logger := log.New()
+	logger.SetLevel(log.WarnLevel)
+	if debug {
+		logger.SetLevel(log.DebugLevel)
+	}
+	return logger
 }
 
 func (c *APIClient) connectWebsocket() (*WebsocketClient, error) {
-	if c.ws != nil {
+	if c.ws != nil && !c.ws.isClosed() {
 		return c.ws, nil
 	}
+	if c.ws != nil {
+		c.resume = c.ws.resumeState()
+	}
 
-	ws, err := newWebsocketClient(c.c)
+	ws, err := c.newWebsocket()
+	if err != nil {
+		return nil, err
+	}
+
+	c.ws = ws
+	return ws, nil
+}
+
+func (c *APIClient) newWebsocket() (*WebsocketClient, error) {
+	ws, err := newWebsocketClient(c.c, c.resume)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to connect websocket")
 	}
 
 	ws.debug = c.c.debug
-
-	c.ws = ws
 	return ws, nil
 }
 
@@ -69,7 +151,8 @@ func (c *APIClient) closeWebsocket() error {
 		return errors.New("websocket not connected")
 	}
 
-	c.ws.close()
+	c.ws.Close()
+	c.resume = c.ws.resumeState()
 	c.ws = nil
 	return nil
 }
@@ -82,44 +165,377 @@ func (c *APIClient) CloseWebsocket() error {
 	return c.closeWebsocket()
 }
 
-// ReconnectWebsocket closes and re-opens the websocket connection.
+// Close closes the websocket connection, if any, and releases idle HTTP
+// connections.
+// The client must not be used afterwards.
+func (c *APIClient) Close() error {
+	c.wsLock.Lock()
+	defer c.wsLock.Unlock()
+
+	if c.ws != nil {
+		c.closeWebsocket()
+	}
+	c.c.c.CloseIdleConnections()
+
+	return nil
+}
+
+// ReconnectWebsocket opens a new websocket connection and, once that
+// succeeded, closes the previous one.
 // Use this in case of any errors with the websocket connection.
+// If connecting fails, the error is returned and the previous connection, if
+// any, is left untouched, so that it can still be closed with CloseWebsocket
+// or reconnected again.
+// The new connection resumes from the cursor of the last message read from the
+// previous one, and skips messages SignalR replays from before that.
+// The groups token of the previous connection is sent along, to rejoin the
+// same groups.
 func (c *APIClient) ReconnectWebsocket() (*WebsocketClient, error) {
 	c.wsLock.Lock()
 	defer c.wsLock.Unlock()
 
-	c.closeWebsocket() // ignore the "not connected" error
+	if c.ws != nil {
+		c.resume = c.ws.resumeState()
+	}
+	ws, err := c.newWebsocket()
+	if err != nil {
+		return nil, err
+	}
 
-	return c.connectWebsocket()
+	if c.ws != nil {
+		c.closeWebsocket()
+	}
+	c.ws = ws
+	return ws, nil
+}
+
+// NegotiateWebsocket performs the first steps of the Live API handshake, i.e.,
+// auth2 and negotiate, and returns both responses.
+// This is useful for debugging or for implementing a custom transport.
+// Use ConnectWebsocket to connect normally.
+func (c *APIClient) NegotiateWebsocket() (*Auth2Response, *NegotiateResponse, error) {
+	auth2Resp, err := c.c.auth2(context.Background())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to auth2")
+	}
+
+	nonce := c.c.nonce()
+	negResp, err := c.c.negotiate(context.Background(), nonce, auth2Resp.Token, auth2Resp.Host)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to negotiate")
+	}
+
+	return auth2Resp, negResp, nil
+}
+
+// invocationTimeout is the time to wait for the result of a hub invocation.
+const invocationTimeout = 30 * time.Second
+
+// SetDeviceEnabled enables or disables mining on a device via the Live API.
+// The websocket is connected if necessary.
+// It returns once the server has sent a MethodStateChanged message for the
+// device, or fails after a timeout.
+// The MethodStateChanged message is delivered as usual.
+//
+// The hub method to invoke must be set via WithSetStateMethod, otherwise
+// ErrNoSetStateMethod is returned.
+func (c *APIClient) SetDeviceEnabled(machineSID, deviceID string, enabled bool) error {
+	if c.c.setStateMethod == "" {
+		return ErrNoSetStateMethod
+	}
+
+	ws, err := c.ConnectWebsocket()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), invocationTimeout)
+	defer cancel()
+
+	return setDevicesEnabled(ctx, ws, c.c.setStateMethod, machineSID, []string{deviceID}, enabled, nil)
+}
+
+// setDevicesEnabled enables or disables mining on the given devices of a
+// machine by invoking the given hub method, and waits for a
+// MethodStateChanged message for every device.
+// If state is not nil, it is updated with the messages.
+func setDevicesEnabled(ctx context.Context, ws *WebsocketClient, method, machineSID string, deviceIDs []string, enabled bool, state *LiveState) error {
+	changes, stop := ws.watchStateChanges()
+	defer stop()
+
+	waiting := make(map[string]struct{})
+	for _, id := range deviceIDs {
+		_, err := ws.Invoke(ctx, method, machineSID, id, enabled)
+		if err != nil {
+			return errors.Wrapf(err, "unable to set state of device %s", id)
+		}
+		waiting[id] = struct{}{}
+	}
+
+	for len(waiting) > 0 {
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "no confirmation for %d devices", len(waiting))
+		case <-ws.closed:
+			return ErrWebsocketClosed
+		case msg := <-changes:
+			if msg.MachineSID != machineSID || msg.Enabled != enabled {
+				continue
+			}
+			if _, ok := waiting[msg.DeviceID]; !ok {
+				continue
+			}
+			delete(waiting, msg.DeviceID)
+			if state != nil {
+				// This only fails if the device is not part of the state,
+				// the enabled flag is recorded anyway.
+				state.UpdateState(msg)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SetMachineEnabled enables or disables mining on all devices of a machine via
+// the Live API.
+// The devices of the machine are looked up with RefreshMachines.
+// It returns once the server has sent a MethodStateChanged message for every
+// device, or fails after a timeout.
+//
+// If state is not nil, devices that are already in the target state according
+// to state.IsDeviceEnabled are skipped, and state is updated with the
+// MethodStateChanged messages as they are confirmed.
+// The messages are delivered to readers as usual, too.
+//
+// The hub method to invoke must be set via WithSetStateMethod, otherwise
+// ErrNoSetStateMethod is returned.
+func (c *APIClient) SetMachineEnabled(sid string, enabled bool, state *LiveState) error {
+	if c.c.setStateMethod == "" {
+		return ErrNoSetStateMethod
+	}
+
+	machines, err := c.RefreshMachines()
+	if err != nil {
+		return errors.Wrap(err, "unable to get machines")
+	}
+
+	machine, err := machines.machine(sid)
+	if err != nil {
+		return err
+	}
+
+	var deviceIDs []string
+	for _, d := range machine.Devices {
+		if state != nil {
+			if current, ok := state.IsDeviceEnabled(d.ID); ok && current == enabled {
+				continue
+			}
+		}
+		deviceIDs = append(deviceIDs, d.ID)
+	}
+	if len(deviceIDs) == 0 {
+		return nil
+	}
+
+	ws, err := c.ConnectWebsocket()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), invocationTimeout)
+	defer cancel()
+
+	return setDevicesEnabled(ctx, ws, c.c.setStateMethod, sid, deviceIDs, enabled, state)
+}
+
+// WaitForDeviceStatus waits until a device reaches the target status, see the
+// Status constants, or the context is done, in which case ctx.Err() is
+// returned.
+// The initial state is fetched with RefreshMachines, updates are read from
+// the Live API, reconnecting on errors.
+//
+// This reads messages off the websocket, so they are not delivered to other
+// readers of the same connection.
+func (c *APIClient) WaitForDeviceStatus(ctx context.Context, machineSID, deviceID string, target int) error {
+	machines, err := c.RefreshMachines()
+	if err != nil {
+		return errors.Wrap(err, "unable to get machines")
+	}
+
+	state := NewLiveState()
+	state.SetSystemInfo(*machines)
+
+	for {
+		if status, ok := state.deviceStatus(machineSID, deviceID); ok && status == target {
+			return nil
+		}
+
+		ws, err := c.ConnectWebsocket()
+		if err != nil {
+			return err
+		}
+
+		messages, err := ws.ReadNextInterestingMessagesContext(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			c.c.logger.WithField("err", err).Warnln("websocket broken, reconnecting")
+			_, err = c.ReconnectWebsocket()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, msg := range messages.Messages {
+			switch msg.Method {
+			case MethodSetSystemInfo:
+				sysInf, err := ParseSystemInfoMessage(msg)
+				if err == nil {
+					for _, m := range sysInf {
+						state.AddMachine(m)
+					}
+				}
+			case MethodStatusChanged:
+				status, err := ParseStatusChangedMessage(msg)
+				if err == nil {
+					state.UpdateStatus(*status) // ignore unknown devices
+				}
+			}
+		}
+	}
 }
 
 // GetWithdrawHistory retrieves the withdraw history.
 func (c *APIClient) GetWithdrawHistory() (*WithdrawHistoryResponse, error) {
-	return c.c.getWithdrawHistory()
+	return c.c.getWithdrawHistory(context.Background())
+}
+
+// GetWithdrawHistoryPage retrieves limit transactions of the withdraw history,
+// starting at offset.
+// The endpoint does not support paging, so this still transfers the entire
+// history, but only returns the requested transactions.
+func (c *APIClient) GetWithdrawHistoryPage(offset, limit int) (*WithdrawHistoryResponse, error) {
+	resp, err := c.c.getWithdrawHistory(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Transactions = page(resp.Transactions, offset, limit)
+	return resp, nil
+}
+
+// page returns the elements of s in [offset, offset+limit), clamped to the
+// bounds of s.
+// A negative limit returns all elements from offset on.
+func page[T any](s []T, offset, limit int) []T {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(s) {
+		offset = len(s)
+	}
+	end := offset + limit
+	if limit < 0 || end > len(s) {
+		end = len(s)
+	}
+
+	return s[offset:end]
+}
+
+// EachTransaction calls fn for each transaction of the withdraw history, in
+// order, until all transactions have been visited, fn returns an error, or
+// the context is done.
+// The error returned by fn, or the context's error, is returned.
+func (c *APIClient) EachTransaction(ctx context.Context, fn func(TransactionEntry) error) error {
+	// The endpoint does not support paging, so everything is fetched at
+	// once. If that changes, fetch page by page here.
+	resp, err := c.c.getWithdrawHistory(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range resp.Transactions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err = fn(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // GetWithdrawData retrieves information about current withdraw options.
+// If caching is enabled via WithCache, a cached response may be returned.
 func (c *APIClient) GetWithdrawData() (*WithdrawDataResponse, error) {
-	return c.c.getWithdrawData()
+	return c.c.getWithdrawData(context.Background(), false)
+}
+
+// RefreshWithdrawData is like GetWithdrawData, but bypasses the cache.
+func (c *APIClient) RefreshWithdrawData() (*WithdrawDataResponse, error) {
+	return c.c.getWithdrawData(context.Background(), true)
 }
 
 // GetMachines gets information about current machines.
 // Please note that the live information contained in this can not be trusted,
 // e.g. the Enabled field will always be set to true, even if a device is not
 // actually enabled.
+// If caching is enabled via WithCache, a cached response may be returned.
 func (c *APIClient) GetMachines() (*MachinesResponse, error) {
-	return c.c.getMachines()
+	return c.c.getMachines(context.Background(), false)
+}
+
+// RefreshMachines is like GetMachines, but bypasses the cache.
+func (c *APIClient) RefreshMachines() (*MachinesResponse, error) {
+	return c.c.getMachines(context.Background(), true)
+}
+
+// GetMachine returns information about the machine with the given SID.
+// There is no endpoint for a single machine, so this fetches all machines
+// via GetMachines.
+// Returns ErrMachineNotFound if there is no such machine.
+func (c *APIClient) GetMachine(sid string) (*MachineEntry, error) {
+	machines, err := c.GetMachines()
+	if err != nil {
+		return nil, err
+	}
+
+	return machines.machine(sid)
+}
+
+// GetExchangeBalance returns the balance reported by the exchange endpoint,
+// using the tokens configured via WithExchangeTokens.
+// Returns ErrNoExchangeTokens if there are none.
+func (c *APIClient) GetExchangeBalance() (*ExchangeResponse, error) {
+	if c.miningToken == "" || c.balanceToken == "" {
+		return nil, ErrNoExchangeTokens
+	}
+
+	return c.c.getExchangeBalance(c.miningToken, c.balanceToken)
 }
 
 // GetStats returns historical statistics.
+// For accounts that have not mined yet, the returned stats are empty, see
+// StatsResponse.IsEmpty.
 func (c *APIClient) GetStats() (*StatsResponse, error) {
-	return c.c.getStats()
+	return c.c.getStats(context.Background())
 }
 
 // UpdateLoginToken performs another login request to update the token returned.
 // You should call this periodically, it looks like winminer invalidates tokens
 // after some time.
+// Returns ErrNoCredentials for clients constructed with NewAPIClientWithToken.
 func (c *APIClient) UpdateLoginToken() error {
+	if c.email == "" && c.password == "" {
+		return ErrNoCredentials
+	}
+
 	_, err := c.c.postLogin(c.email, c.password)
 	return err
 }
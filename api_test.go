@@ -0,0 +1,62 @@
+package winminer_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/mrd0ll4r/winminer"
+	"github.com/mrd0ll4r/winminer/winminertest"
+)
+
+func TestSetMachineEnabledSkipsDevicesInTargetState(t *testing.T) {
+	s := winminertest.NewServer()
+	defer s.Close()
+	s.SetMachines(winminer.MachinesResponse{{
+		SID:     "sid",
+		Devices: []winminer.DeviceEntry{{ID: "d1"}, {ID: "d2"}},
+	}})
+	c := newTestClient(t, s, winminer.WithSetStateMethod("SetState"))
+
+	state := winminer.NewLiveState()
+	state.UpdateState(winminer.StateChangedMessage{MachineSID: "sid", DeviceID: "d1", Enabled: false})
+
+	confirmNextInvocation(s, s.Message(winminer.MethodStateChanged, "sid", "d2", false))
+	err := c.SetMachineEnabled("sid", false, state)
+	if err != nil {
+		t.Fatalf("SetMachineEnabled failed: %s", err)
+	}
+
+	var invocations [][]byte
+	for _, b := range s.Received() {
+		if bytes.Contains(b, []byte(`"M":"SetState"`)) {
+			invocations = append(invocations, b)
+		}
+	}
+	if len(invocations) != 1 || !bytes.Contains(invocations[0], []byte(`"d2"`)) {
+		t.Errorf("expected a single invocation for d2, got %q", invocations)
+	}
+
+	enabled, ok := state.IsDeviceEnabled("d2")
+	if !ok || enabled {
+		t.Errorf("expected state to be updated for d2, got enabled=%v ok=%v", enabled, ok)
+	}
+}
+
+func TestSetStateRequiresMethod(t *testing.T) {
+	s := winminertest.NewServer()
+	defer s.Close()
+	c := newTestClient(t, s)
+
+	err := c.SetDeviceEnabled("sid", "d1", false)
+	if !errors.Is(err, winminer.ErrNoSetStateMethod) {
+		t.Errorf("expected SetDeviceEnabled to fail with ErrNoSetStateMethod, got %v", err)
+	}
+	err = c.SetMachineEnabled("sid", false, nil)
+	if !errors.Is(err, winminer.ErrNoSetStateMethod) {
+		t.Errorf("expected SetMachineEnabled to fail with ErrNoSetStateMethod, got %v", err)
+	}
+	if len(s.Received()) != 0 {
+		t.Errorf("expected nothing to be sent, got %q", s.Received())
+	}
+}
@@ -0,0 +1,72 @@
+package winminer
+
+import (
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"golang.org/x/sync/errgroup"
+)
+
+// Balances holds the balances reported by the different endpoints.
+//
+// They may differ, because they are computed at different points in the
+// payout pipeline:
+// The confirmed balance includes all rewards credited to the account.
+// The withdrawable balance is what can be withdrawn right now, which may lag
+// behind or exclude rewards that have not been cleared yet.
+// The mining balance is what the miner application displays, which is
+// updated more frequently and may include rewards that have not been
+// confirmed yet.
+type Balances struct {
+	// Confirmed is the balance reported with the stats, in USD.
+	Confirmed decimal.Decimal
+
+	// Withdrawable is the balance reported with the withdraw data, in USD.
+	Withdrawable decimal.Decimal
+
+	// Mining is the balance reported by the exchange endpoint, in USD.
+	// It is only set if HasMining is true, which requires exchange tokens to
+	// be configured via WithExchangeTokens.
+	Mining    decimal.Decimal
+	HasMining bool
+}
+
+// Balances fetches the balances from all endpoints concurrently.
+func (c *APIClient) Balances() (*Balances, error) {
+	var (
+		g            errgroup.Group
+		stats        *StatsResponse
+		withdrawData *WithdrawDataResponse
+		exchange     *ExchangeResponse
+	)
+
+	g.Go(func() (err error) {
+		stats, err = c.GetStats()
+		return
+	})
+	g.Go(func() (err error) {
+		withdrawData, err = c.GetWithdrawData()
+		return
+	})
+	if c.miningToken != "" && c.balanceToken != "" {
+		g.Go(func() (err error) {
+			exchange, err = c.c.getExchangeBalance(c.miningToken, c.balanceToken)
+			return
+		})
+	}
+
+	err := g.Wait()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get balances")
+	}
+
+	b := Balances{
+		Confirmed:    stats.Balance,
+		Withdrawable: withdrawData.Balance,
+	}
+	if exchange != nil {
+		b.Mining = exchange.UserBalance
+		b.HasMining = true
+	}
+
+	return &b, nil
+}
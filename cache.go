@@ -0,0 +1,84 @@
+package winminer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A responseCache caches raw responses of GET endpoints for a fixed TTL.
+type responseCache struct {
+	ttl     time.Duration
+	entries map[string]cacheEntry
+	lock    sync.Mutex
+}
+
+type cacheEntry struct {
+	b       []byte
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// get returns the cached response for key, if it has not expired at now.
+func (c *responseCache) get(key string, now time.Time) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return e.b, true
+}
+
+// put caches a response for key, received at now.
+func (c *responseCache) put(key string, b []byte, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.entries[key] = cacheEntry{b: b, expires: now.Add(c.ttl)}
+}
+
+// getCached performs an authenticated GET request, using the cache if it is
+// enabled.
+// If force is set, the cache is bypassed, but updated with the new response.
+func (c *lowLevelClient) getCached(ctx context.Context, force bool, url string, response interface{}) error {
+	if c.cache == nil {
+		return c.doContext(ctx, http.MethodGet, true, url, nil, nil, response)
+	}
+
+	if !force {
+		if b, ok := c.cache.get(url, c.now()); ok {
+			return c.decode(b, response)
+		}
+	}
+
+	var raw json.RawMessage
+	err := c.doContext(ctx, http.MethodGet, true, url, nil, nil, &raw)
+	if err != nil {
+		return err
+	}
+	c.cache.put(url, raw, c.now())
+
+	err = c.decode(raw, response)
+	if err != nil {
+		return errors.Wrapf(err, "unable to decode response (raw: %s)", redactBody(raw))
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package winminer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheTTL(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		requests = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case machinesPath:
+			w.Write([]byte(`[]`))
+		case withdrawDataPath:
+			w.Write([]byte(`{"balance":1}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	c := newAPIClient("", "", false, []Option{
+		WithBaseURL(srv.URL),
+		WithCache(time.Minute),
+		WithClock(func() time.Time { return now }),
+	})
+
+	getMachines := func() error { _, err := c.GetMachines(); return err }
+	refreshMachines := func() error { _, err := c.RefreshMachines(); return err }
+	getWithdrawData := func() error { _, err := c.GetWithdrawData(); return err }
+
+	tests := []struct {
+		name     string
+		at       time.Duration
+		call     func() error
+		machines int // requests to machinesPath after the call
+		withdraw int // requests to withdrawDataPath after the call
+	}{
+		{"first request", 0, getMachines, 1, 0},
+		{"cached", 30 * time.Second, getMachines, 1, 0},
+		{"cached until the TTL", time.Minute, getMachines, 1, 0},
+		{"other endpoint", time.Minute, getWithdrawData, 1, 1},
+		{"expired", time.Minute + time.Second, getMachines, 2, 1},
+		{"cached again", 90 * time.Second, getMachines, 2, 1},
+		{"refresh bypasses", 90 * time.Second, refreshMachines, 3, 1},
+		{"refresh updates", 2*time.Minute + 30*time.Second, getMachines, 3, 1},
+		{"expired after refresh", 2*time.Minute + 31*time.Second, getMachines, 4, 1},
+		{"other endpoint expired", 2*time.Minute + 31*time.Second, getWithdrawData, 4, 2},
+	}
+
+	for _, test := range tests {
+		now = start.Add(test.at)
+		if err := test.call(); err != nil {
+			t.Fatalf("%s: request failed: %s", test.name, err)
+		}
+
+		lock.Lock()
+		machines, withdraw := requests[machinesPath], requests[withdrawDataPath]
+		lock.Unlock()
+		if machines != test.machines || withdraw != test.withdraw {
+			t.Errorf("%s: expected %d machines and %d withdraw data requests, got %d and %d", test.name, test.machines, test.withdraw, machines, withdraw)
+		}
+	}
+}
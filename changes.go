@@ -0,0 +1,150 @@
+package winminer
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// A StateChange describes a change to a LiveState, see ApplyWithChanges.
+type StateChange struct {
+	MachineSID string
+	// DeviceID is empty if a whole machine was added or removed.
+	DeviceID string
+
+	// Added and Removed are set if the machine or device was added or
+	// removed, respectively.
+	// The status and enabled flag before an addition and after a removal are
+	// zero.
+	Added   bool
+	Removed bool
+
+	OldStatus  int
+	NewStatus  int
+	OldEnabled bool
+	NewEnabled bool
+}
+
+func (c StateChange) String() string {
+	id := c.MachineSID
+	if c.DeviceID != "" {
+		id += "/" + c.DeviceID
+	}
+
+	switch {
+	case c.Added:
+		return fmt.Sprintf("%s added", id)
+	case c.Removed:
+		return fmt.Sprintf("%s removed", id)
+	default:
+		return fmt.Sprintf("%s status %d -> %d, enabled %t -> %t", id, c.OldStatus, c.NewStatus, c.OldEnabled, c.NewEnabled)
+	}
+}
+
+// stateChanges converts a MachinesDiff to StateChanges.
+func stateChanges(d MachinesDiff) []StateChange {
+	var changes []StateChange
+	for _, m := range d.Added {
+		changes = append(changes, StateChange{MachineSID: m.SID, Added: true})
+	}
+	for _, m := range d.Removed {
+		changes = append(changes, StateChange{MachineSID: m.SID, Removed: true})
+	}
+	for _, c := range d.Changed {
+		change := StateChange{MachineSID: c.MachineSID, DeviceID: c.DeviceID}
+		if c.Old == nil {
+			change.Added = true
+		} else {
+			change.OldStatus = c.Old.Status.Status
+			change.OldEnabled = c.Old.Enabled
+		}
+		if c.New == nil {
+			change.Removed = true
+		} else {
+			change.NewStatus = c.New.Status.Status
+			change.NewEnabled = c.New.Enabled
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// machineCopy returns a copy of the machine with the given SID, including its
+// devices, so that later updates do not modify it.
+// The result is empty if the machine is not known.
+// The caller must hold the lock.
+func (s *LiveState) machineCopy(sid string) MachinesResponse {
+	for _, m := range s.Machines {
+		if m.SID == sid {
+			m.Devices = append([]DeviceEntry(nil), m.Devices...)
+			return MachinesResponse{m}
+		}
+	}
+
+	return nil
+}
+
+// ApplyWithChanges applies the messages of a container to the state and
+// returns the resulting changes, in order.
+// The machines of system info are added or replaced, see AddMachine, status
+// and state changes update a device, and alerts are added or removed.
+// Other messages are ignored.
+// The lock is held for the whole container, so the changes are exactly those
+// caused by its messages.
+//
+// Messages that can not be parsed or applied, e.g. because the device is not
+// known, are skipped, and the first such error is returned with the changes
+// of the other messages.
+func (s *LiveState) ApplyWithChanges(container *RawMessageContainer) ([]StateChange, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var (
+		changes  []StateChange
+		firstErr error
+	)
+
+	// apply applies a change to the machine with the given SID and records
+	// the resulting changes.
+	apply := func(sid string, f func() error) error {
+		before := s.machineCopy(sid)
+		err := f()
+		changes = append(changes, stateChanges(before.Diff(s.machineCopy(sid)))...)
+		return err
+	}
+
+	for _, msg := range container.Messages {
+		parsed, err := ParseMessage(msg)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "unable to parse %s message", msg.Method)
+			}
+			continue
+		}
+
+		switch m := parsed.(type) {
+		case *SystemInfoMessage:
+			for _, entry := range m.Machines {
+				entry := entry
+				apply(entry.SID, func() error {
+					s.addMachine(entry)
+					return nil
+				})
+			}
+		case *StatusChangedMessage:
+			err = apply(m.MachineSID, func() error { return s.updateStatus(*m) })
+		case *StateChangedMessage:
+			err = apply(m.MachineSID, func() error { return s.updateState(*m) })
+		case *AlertAddedMessage:
+			s.addAlert(m.Alert)
+		case *AlertRemovedMessage:
+			s.removeAlert(m.Alert)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "unable to apply %s message", msg.Method)
+		}
+	}
+
+	return changes, firstErr
+}
@@ -0,0 +1,155 @@
+package winminer
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testContainer decodes the given hub messages into a container.
+func testContainer(t *testing.T, messages ...string) *RawMessageContainer {
+	t.Helper()
+
+	var c RawMessageContainer
+	err := json.Unmarshal([]byte(`{"M":[`+strings.Join(messages, ",")+`]}`), &c)
+	if err != nil {
+		t.Fatalf("unable to decode messages: %s", err)
+	}
+
+	return &c
+}
+
+func testMachines() []MachineEntry {
+	return []MachineEntry{{
+		SID: "sid",
+		Devices: []DeviceEntry{
+			{ID: "0", Enabled: true, Status: DeviceStatus{Status: StatusStopping}},
+			{ID: "1", Enabled: true, Status: DeviceStatus{Status: StatusMining}},
+		},
+	}}
+}
+
+func TestApplyWithChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		changes  []StateChange
+		err      bool
+	}{
+		{
+			name:     "status",
+			messages: []string{`{"M":"StatusChanged","A":["sid","0",{"status":8}]}`},
+			changes:  []StateChange{{MachineSID: "sid", DeviceID: "0", OldStatus: StatusStopping, NewStatus: StatusMining, OldEnabled: true, NewEnabled: true}},
+		},
+		{
+			name:     "unchanged status",
+			messages: []string{`{"M":"StatusChanged","A":["sid","1",{"status":8}]}`},
+		},
+		{
+			name:     "state",
+			messages: []string{`{"M":"StateChanged","A":["sid","1",false]}`},
+			changes:  []StateChange{{MachineSID: "sid", DeviceID: "1", OldStatus: StatusMining, NewStatus: StatusMining, OldEnabled: true, NewEnabled: false}},
+		},
+		{
+			name: "in order",
+			messages: []string{
+				`{"M":"StatusChanged","A":["sid","0",{"status":8}]}`,
+				`{"M":"StatusChanged","A":["sid","0",{"status":0}]}`,
+			},
+			changes: []StateChange{
+				{MachineSID: "sid", DeviceID: "0", OldStatus: StatusStopping, NewStatus: StatusMining, OldEnabled: true, NewEnabled: true},
+				{MachineSID: "sid", DeviceID: "0", OldStatus: StatusMining, NewStatus: StatusStopping, OldEnabled: true, NewEnabled: true},
+			},
+		},
+		{
+			name: "system info",
+			messages: []string{
+				`{"M":"SetSystemInfo","A":["client","other",{"sid":"other","devices":[]}]}`,
+				`{"M":"SetSystemInfo","A":["client","sid",{"sid":"sid","devices":[{"id":"0","enabled":true,"status":{"status":0}}]}]}`,
+			},
+			changes: []StateChange{
+				{MachineSID: "other", Added: true},
+				{MachineSID: "sid", DeviceID: "1", Removed: true, OldStatus: StatusMining, OldEnabled: true},
+			},
+		},
+		{
+			name: "errors are skipped",
+			messages: []string{
+				`{"M":"StatusChanged","A":["sid","unknown",{"status":8}]}`,
+				`{"M":"StatusChanged","A":["sid"]}`,
+				`{"M":"StateChanged","A":["sid","0",false]}`,
+			},
+			changes: []StateChange{{MachineSID: "sid", DeviceID: "0", OldStatus: StatusStopping, NewStatus: StatusStopping, OldEnabled: true, NewEnabled: false}},
+			err:     true,
+		},
+		{
+			name: "alerts and other messages",
+			messages: []string{
+				`{"M":"AddMessage","A":["sid",{"id":1,"text":"GPU too hot"}]}`,
+				`{"M":"MiningStarted","A":["client"]}`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		s := NewLiveState()
+		s.SetSystemInfo(testMachines())
+
+		changes, err := s.ApplyWithChanges(testContainer(t, test.messages...))
+		if (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+		if len(changes) != len(test.changes) {
+			t.Errorf("%s: expected changes %v, got %v", test.name, test.changes, changes)
+			continue
+		}
+		for i := range changes {
+			if changes[i] != test.changes[i] {
+				t.Errorf("%s: expected change %d to be %v, got %v", test.name, i, test.changes[i], changes[i])
+			}
+		}
+	}
+}
+
+func TestApplyWithChangesConcurrentUpdates(t *testing.T) {
+	s := NewLiveState()
+	s.SetSystemInfo(append(testMachines(), MachineEntry{SID: "other", Devices: []DeviceEntry{{ID: "2"}}}))
+
+	var messages []string
+	for i := 0; i < 100; i++ {
+		messages = append(messages, `{"M":"StatusChanged","A":["sid","0",{"status":8}]}`, `{"M":"StatusChanged","A":["sid","0",{"status":0}]}`)
+	}
+	container := testContainer(t, messages...)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for status := 0; ; status++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s.UpdateStatus(StatusChangedMessage{MachineSID: "other", DeviceID: "2", Status: DeviceStatus{Status: status % 10}})
+		}
+	}()
+
+	changes, err := s.ApplyWithChanges(container)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("unable to apply: %s", err)
+	}
+
+	if len(changes) != len(messages) {
+		t.Errorf("expected %d changes, got %d", len(messages), len(changes))
+	}
+	for _, c := range changes {
+		if c.MachineSID != "sid" {
+			t.Errorf("change %v was not caused by the container", c)
+		}
+	}
+}
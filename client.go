@@ -2,15 +2,22 @@ package winminer
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
@@ -18,16 +25,16 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// API URLs.
+// API base URL and endpoint paths.
 const (
-	apiBaseURL         = "https://api.winminer.com"
-	loginURL           = apiBaseURL + "/user/login"
-	statsURL           = apiBaseURL + "/user/stats"
-	withdrawHistoryURL = apiBaseURL + "/user/withdraw-history"
-	exchangeURL        = apiBaseURL + "/coin/exchange"
-	withdrawDataURL    = apiBaseURL + "/withdraw/data"
-	machinesURL        = apiBaseURL + "/hub/machines"
-	hubAuth2URL        = apiBaseURL + "/hub/auth2"
+	defaultAPIBaseURL   = "https://api.winminer.com"
+	loginPath           = "/user/login"
+	statsPath           = "/user/stats"
+	withdrawHistoryPath = "/user/withdraw-history"
+	exchangePath        = "/coin/exchange"
+	withdrawDataPath    = "/withdraw/data"
+	machinesPath        = "/hub/machines"
+	hubAuth2Path        = "/hub/auth2"
 )
 
 // JSON content type.
@@ -37,57 +44,194 @@ const (
 
 type lowLevelClient struct {
 	c             *http.Client
+	apiBaseURL    string
 	userToken     string
 	userTokenLock sync.RWMutex
 	debug         bool
+
+	retryAttempts  int
+	retryBaseDelay time.Duration
+
+	wsCompression bool
+	strictJSON    bool
+	streamDecode  bool
+	isMaintenance func(body []byte) bool
+
+	cache *responseCache
+
+	transport         Transport
+	hubName           string
+	keepAliveMethod   string
+	setStateMethod    string
+	pingInterval      time.Duration
+	keepAliveInterval time.Duration
+	maxFrameSize      int64
+	wsDialTimeout     time.Duration
+	silenceWatchdog   bool
+	responseSizeLimit int64
+	tracer            Tracer
+	headers           http.Header
+	tlsConfig         *tls.Config
+
+	now    func() time.Time
+	logger *log.Logger
+}
+
+// nonce returns the initial nonce for SignalR requests, which is the current
+// time in milliseconds.
+// The server only requires nonces to be increasing.
+func (c *lowLevelClient) nonce() int64 {
+	return c.now().UnixNano() / 1000000
+}
+
+// connectionData returns the SignalR connectionData parameter, which lists the
+// hubs to connect to.
+func (c *lowLevelClient) connectionData() string {
+	b, _ := json.Marshal([]struct {
+		Name string `json:"name"`
+	}{{Name: c.hubName}})
+	return string(b)
+}
+
+// tls returns the TLS configuration to modify, creating it if necessary.
+func (c *lowLevelClient) tls() *tls.Config {
+	if c.tlsConfig == nil {
+		c.tlsConfig = &tls.Config{}
+	}
+	return c.tlsConfig
+}
+
+// applyTLSConfig configures the HTTP client to use the TLS configuration, if
+// one was set.
+func (c *lowLevelClient) applyTLSConfig() {
+	if c.tlsConfig == nil {
+		return
+	}
+
+	var t *http.Transport
+	switch rt := c.c.Transport.(type) {
+	case nil:
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		t = rt.Clone()
+	default:
+		c.logger.Warnln("custom HTTP transport, unable to apply TLS configuration")
+		return
+	}
+	t.TLSClientConfig = c.tlsConfig
+
+	// Don't modify the caller's client.
+	hc := *c.c
+	hc.Transport = t
+	c.c = &hc
+}
+
+// dialer returns the websocket dialer to use for the Live API.
+func (c *lowLevelClient) dialer() *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	d.EnableCompression = c.wsCompression
+	d.TLSClientConfig = c.tlsConfig
+	if c.wsDialTimeout > 0 {
+		d.HandshakeTimeout = c.wsDialTimeout
+	}
+	return &d
 }
 
-func (c *lowLevelClient) connect(auth2Token, hubBaseURL, connectionToken string) (*websocket.Conn, error) {
+func (c *lowLevelClient) connect(auth2Token, hubBaseURL, connectionToken string, resume *resumeState) (transport, error) {
 	// this does not need to be a method of lowLevelClient, but we'll leave it like that for now
 
 	v := url.Values{}
 	v.Set("clientProtocol", "1.5")
-	v.Set("connectionData", "[{\"name\":\"reportinghub\"}]")
+	v.Set("connectionData", c.connectionData())
 	v.Set("token", auth2Token)
-	v.Set("transport", "webSockets")
+	v.Set("transport", string(TransportWebSockets))
 	v.Set("tid", "10")
 	v.Set("connectionToken", connectionToken)
+	if resume.cursor != "" {
+		v.Set("messageId", resume.cursor)
+	}
+	if resume.groupsToken != "" {
+		v.Set("groupsToken", resume.groupsToken)
+	}
 
-	d := websocket.DefaultDialer
-	wsUrl := "wss:" + strings.Split(hubBaseURL, ":")[1]
-	conn, _, err := d.Dial(wsUrl+"/signalr/connect?"+v.Encode(), http.Header{})
+	d := c.dialer()
+	split := strings.SplitN(hubBaseURL, ":", 2)
+	if len(split) != 2 {
+		return nil, fmt.Errorf("invalid hub URL %q", hubBaseURL)
+	}
+	wsUrl := "wss:" + split[1]
+	if split[0] == "http" {
+		wsUrl = "ws:" + split[1]
+	}
+	h := http.Header{}
+	c.applyHeaders(h)
+	conn, resp, err := d.Dial(wsUrl+"/signalr/connect?"+v.Encode(), h)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to open WebSockets connection")
 	}
 
+	// If the server does not support compression, the connection is simply
+	// not compressed.
+	if c.debug && d.EnableCompression {
+		compressed := strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+		c.logger.WithField("compressed", compressed).Debugln("negotiated websocket compression")
+	}
+
+	if c.maxFrameSize > 0 {
+		return &limitedConn{Conn: conn, limit: c.maxFrameSize}, nil
+	}
 	return conn, nil
 }
 
+// A limitedConn is a websocket connection that limits the size of messages
+// after decompression.
+// websocket.Conn.SetReadLimit only limits the size on the wire, which is
+// much smaller than the message for compressed messages.
+type limitedConn struct {
+	*websocket.Conn
+	limit int64
+}
+
+// ReadMessage reads the next message.
+// Messages larger than the limit are not read into memory, ErrFrameTooLarge
+// is returned instead.
+func (c *limitedConn) ReadMessage() (int, []byte, error) {
+	messageType, r, err := c.NextReader()
+	if err != nil {
+		return messageType, nil, err
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, c.limit+1))
+	if err != nil {
+		return messageType, nil, err
+	}
+	if int64(len(b)) > c.limit {
+		return messageType, nil, errors.Wrapf(ErrFrameTooLarge, "frame exceeds %d bytes", c.limit)
+	}
+
+	return messageType, b, nil
+}
+
 // A GenericSignalrResponse is used for both the /start and /ping endpoint of
 // the live API "signalr" endpoint.
 type GenericSignalrResponse struct {
 	Response string `json:"Response"`
 }
 
-func (c *lowLevelClient) start(nonce int64, auth2Token, hubBaseURL, connectionToken string) error {
+func (c *lowLevelClient) start(ctx context.Context, nonce int64, transport Transport, auth2Token, hubBaseURL, connectionToken string) error {
 	v := url.Values{}
 	v.Set("clientProtocol", "1.5")
-	v.Set("connectionData", "[{\"name\":\"reportinghub\"}]")
+	v.Set("connectionData", c.connectionData())
 	v.Set("connectionToken", connectionToken)
 	v.Set("token", auth2Token)
 	v.Set("_", fmt.Sprint(nonce))
-	v.Set("transport", "webSockets")
-	var resp GenericSignalrResponse
+	v.Set("transport", string(transport))
 
-	err := c.do(http.MethodGet, false, hubBaseURL+"/signalr/start", v, nil, &resp)
+	err := c.signalrCommand(ctx, hubBaseURL+"/signalr/start", v, "started")
 	if err != nil {
 		return errors.Wrap(err, "unable to start")
 	}
 
-	if resp.Response != "started" {
-		return errors.New("did not receive a started response")
-	}
-
 	return nil
 }
 
@@ -95,15 +239,34 @@ func (c *lowLevelClient) ping(nonce int64, auth2Token, hubBaseURL string) error
 	v := url.Values{}
 	v.Set("token", auth2Token)
 	v.Set("_", fmt.Sprint(nonce))
-	var resp GenericSignalrResponse
 
-	err := c.do(http.MethodGet, false, hubBaseURL+"/signalr/ping", v, nil, &resp)
+	err := c.signalrCommand(context.Background(), hubBaseURL+"/signalr/ping", v, "pong")
 	if err != nil {
 		return errors.Wrap(err, "unable to ping")
 	}
 
-	if resp.Response != "pong" {
-		return errors.New("did not receive a pong response")
+	return nil
+}
+
+// signalrCommand requests a SignalR endpoint that responds with a
+// GenericSignalrResponse and checks that the response is the expected one.
+// Otherwise, the raw body is included in the error.
+// Error responses with a non-200 status code result in an APIError, which
+// includes the body as well.
+func (c *lowLevelClient) signalrCommand(ctx context.Context, url string, v url.Values, expected string) error {
+	var raw json.RawMessage
+	err := c.doContext(ctx, http.MethodGet, false, url, v, nil, &raw)
+	if err != nil {
+		return err
+	}
+
+	var resp GenericSignalrResponse
+	err = c.decode(raw, &resp)
+	if err != nil {
+		return errors.Wrapf(err, "unable to decode response (raw: %s)", redactBody(raw))
+	}
+	if resp.Response != expected {
+		return errors.Errorf("did not receive a %s response (raw: %s)", expected, redactBody(raw))
 	}
 
 	return nil
@@ -124,15 +287,15 @@ type NegotiateResponse struct {
 	LongPollDelay              decimal.Decimal `json:"LongPollDelay"`
 }
 
-func (c *lowLevelClient) negotiate(nonce int64, auth2Token, hubBaseURL string) (*NegotiateResponse, error) {
+func (c *lowLevelClient) negotiate(ctx context.Context, nonce int64, auth2Token, hubBaseURL string) (*NegotiateResponse, error) {
 	v := url.Values{}
 	v.Set("clientProtocol", "1.5")
-	v.Set("connectionData", "[{\"name\":\"reportinghub\"}]")
+	v.Set("connectionData", c.connectionData())
 	v.Set("token", auth2Token)
 	v.Set("_", fmt.Sprint(nonce))
 	var resp NegotiateResponse
 
-	err := c.do(http.MethodGet, false, hubBaseURL+"/signalr/negotiate", v, nil, &resp)
+	err := c.doContext(ctx, http.MethodGet, false, hubBaseURL+"/signalr/negotiate", v, nil, &resp)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to negotiate")
 	}
@@ -156,7 +319,7 @@ type LitecoinTransaction struct {
 // A JWTEntry holds signed(?) information about litecoin(?) transaction
 // specifics.
 type JWTEntry struct {
-	Data                string          `json:"data"` // never seen, no idea what type
+	Data                FlexString      `json:"data"` // never seen, no idea what type
 	BaseCurrency        string          `json:"baseCurrency"`
 	BaseAmount          decimal.Decimal `json:"baseAmount"`
 	WithholdingTax      decimal.Decimal `json:"withholdingTax"`
@@ -170,33 +333,90 @@ type JWTEntry struct {
 	FriendlyProviderFee string          `json:"fProviderFee"`
 	FriendlyNetAmount   string          `json:"fNetAmount"`
 	// JWT stuff
-	ExpirationTime float64 `json:"exp"`
-	JWTID          string  `json:"jti"`
-	IssuedAt       float64 `json:"iat"`
-	Issuer         string  `json:"iss"`
+	ExpirationTime FlexFloat `json:"exp"`
+	JWTID          string    `json:"jti"`
+	IssuedAt       FlexFloat `json:"iat"`
+	Issuer         string    `json:"iss"`
+}
+
+// IsExpired returns whether the expiration time of the JWT has passed.
+// A JWT without an expiration time never expires.
+func (e JWTEntry) IsExpired() bool {
+	if e.ExpirationTime == 0 {
+		return false
+	}
+
+	sec, frac := math.Modf(float64(e.ExpirationTime))
+	exp := time.Unix(int64(sec), int64(frac*1e9))
+	return !time.Now().Before(exp)
 }
 
 // A TransactionEntry holds information about one withdrawal.
 // After determining the type of the transaction, parse the TransactionData
 // using e.g. ParseAsLitecoinTransaction.
 type TransactionEntry struct {
-	TransactionID           string `json:"transactionId"`
-	IsCompleted             bool   `json:"isCompleted"`
-	CompletedDate           string `json:"completedDate"` // never seen, but probably string
-	RequestDate             string `json:"requestDate"`
-	TransactionType         int    `json:"transactionType"`
-	Status                  int    `json:"status"`
-	TransactionData         string `json:"transactionData"`
-	FriendlyStatus          string `json:"friendlyStatus"`
-	FriendlyTransactionType string `json:"friendlyTransactionType"`
-	Data                    string `json:"data"`
-	FriendlyTotalAmount     string `json:"friendlyTotalAmount"`
-	FriendlyNetAmount       string `json:"friendlyNetAmount"`
-	FriendlyWinMinerFees    string `json:"friendlyWinMinerFees"`
-	FriendlyProviderFees    string `json:"friendlyProviderFees"`
-	ProviderName            string `json:"providerName"`
-	ExternalTransactionID   string `json:"externalTransactionId"`
-	IP                      string `json:"ip"`
+	TransactionID           string            `json:"transactionId"`
+	IsCompleted             bool              `json:"isCompleted"`
+	CompletedDate           FlexString        `json:"completedDate"` // never seen, but probably string
+	RequestDate             string            `json:"requestDate"`
+	TransactionType         TransactionType   `json:"transactionType"`
+	Status                  TransactionStatus `json:"status"`
+	TransactionData         string            `json:"transactionData"`
+	FriendlyStatus          string            `json:"friendlyStatus"`
+	FriendlyTransactionType string            `json:"friendlyTransactionType"`
+	Data                    FlexString        `json:"data"`
+	FriendlyTotalAmount     string            `json:"friendlyTotalAmount"`
+	FriendlyNetAmount       string            `json:"friendlyNetAmount"`
+	FriendlyWinMinerFees    string            `json:"friendlyWinMinerFees"`
+	FriendlyProviderFees    string            `json:"friendlyProviderFees"`
+	ProviderName            string            `json:"providerName"`
+	ExternalTransactionID   string            `json:"externalTransactionId"`
+	IP                      string            `json:"ip"`
+}
+
+// A TransactionType is the type of a withdrawal.
+type TransactionType int
+
+// Transaction type constants.
+// These are unconfirmed guesses, no captured withdraw history backs them.
+// Check FriendlyTransactionType before relying on them.
+const (
+	TransactionTypeLitecoin TransactionType = 3
+)
+
+// String returns a human-readable name of the transaction type.
+// Use FriendlyTransactionType for types that have no constant.
+func (t TransactionType) String() string {
+	switch t {
+	case TransactionTypeLitecoin:
+		return "Litecoin"
+	default:
+		return fmt.Sprintf("TransactionType(%d)", int(t))
+	}
+}
+
+// A TransactionStatus is the status of a withdrawal.
+type TransactionStatus int
+
+// Transaction status constants.
+// These are unconfirmed guesses, no captured withdraw history backs them.
+// Check FriendlyStatus before relying on them.
+const (
+	TransactionStatusPending   TransactionStatus = 0
+	TransactionStatusCompleted TransactionStatus = 1
+)
+
+// String returns a human-readable name of the transaction status.
+// Use FriendlyStatus for statuses that have no constant.
+func (s TransactionStatus) String() string {
+	switch s {
+	case TransactionStatusPending:
+		return "Pending"
+	case TransactionStatusCompleted:
+		return "Completed"
+	default:
+		return fmt.Sprintf("TransactionStatus(%d)", int(s))
+	}
 }
 
 // ParseDataAsLitecoinTransaction parses the TransactionData as a
@@ -211,10 +431,72 @@ func (e TransactionEntry) ParseDataAsLitecoinTransaction() (*LitecoinTransaction
 	return &t, nil
 }
 
-func (c *lowLevelClient) getWithdrawHistory() (*WithdrawHistoryResponse, error) {
+// DecodeData decodes the Data field, which is an opaque string that may be
+// base64 or hex encoded.
+// See decodeBlob for details.
+func (e TransactionEntry) DecodeData() ([]byte, error) {
+	return decodeBlob(string(e.Data))
+}
+
+// DecodeData decodes the Data field, which is an opaque string that may be
+// base64 or hex encoded.
+// See decodeBlob for details.
+func (e JWTEntry) DecodeData() ([]byte, error) {
+	return decodeBlob(string(e.Data))
+}
+
+// decodeBlob decodes s as base64, trying the standard and URL-safe alphabets
+// with and without padding, then as hex, and finally uses s itself.
+// The first result that looks valid, i.e., is JSON or printable UTF-8 text,
+// is returned.
+func decodeBlob(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("no data")
+	}
+
+	encodings := []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding}
+	for _, enc := range encodings {
+		if b, err := enc.DecodeString(s); err == nil && looksValid(b) {
+			return b, nil
+		}
+	}
+
+	if b, err := hex.DecodeString(s); err == nil && looksValid(b) {
+		return b, nil
+	}
+
+	if looksValid([]byte(s)) {
+		return []byte(s), nil
+	}
+
+	return nil, errors.New("data is neither base64, hex, nor plain text")
+}
+
+// looksValid returns whether b is JSON or printable UTF-8 text.
+func looksValid(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	if json.Valid(b) {
+		return true
+	}
+	if !utf8.Valid(b) {
+		return false
+	}
+
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *lowLevelClient) getWithdrawHistory(ctx context.Context) (*WithdrawHistoryResponse, error) {
 	var resp WithdrawHistoryResponse
 
-	err := c.do(http.MethodGet, true, withdrawHistoryURL, nil, nil, &resp)
+	err := c.doContext(ctx, http.MethodGet, true, c.apiBaseURL+withdrawHistoryPath, nil, nil, &resp)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get withdraw history")
 	}
@@ -223,6 +505,7 @@ func (c *lowLevelClient) getWithdrawHistory() (*WithdrawHistoryResponse, error)
 }
 
 // A WithdrawDataResponse is the response to a WithdrawData request.
+// It does not contain the withdraw options, see WithdrawOptions.
 type WithdrawDataResponse struct {
 	AppleGiftCards  []GiftCardEntry `json:"appleGiftCards"`
 	AmazonGiftCards []GiftCardEntry `json:"amazonGiftCards"`
@@ -232,6 +515,8 @@ type WithdrawDataResponse struct {
 }
 
 // A WithdrawOption describes one withdraw option.
+// These are not returned by any endpoint known to us, they are probably
+// part of the website's templates.
 // Lots of the fields are just required for rendering, but kept here for
 // completeness.
 type WithdrawOption struct {
@@ -248,7 +533,7 @@ type WithdrawOption struct {
 	ConfirmMessage                   []string        `json:"confirmMessage"`
 	ConfirmMessageTokenValueProperty string          `json:"confirmMessageTokenValueProperty"`
 	Disabled                         bool            `json:"disabled"`
-	Message                          string          `json:"message"` // never seen, no idea what type
+	Message                          FlexString      `json:"message"` // never seen, no idea what type
 	AllowHighFee                     bool            `json:"allowHighFee"`
 }
 
@@ -279,10 +564,10 @@ type GiftCardEntry struct {
 	Symbol      string `json:"symbol"`
 }
 
-func (c *lowLevelClient) getWithdrawData() (*WithdrawDataResponse, error) {
+func (c *lowLevelClient) getWithdrawData(ctx context.Context, force bool) (*WithdrawDataResponse, error) {
 	var resp WithdrawDataResponse
 
-	err := c.do(http.MethodGet, true, withdrawDataURL, nil, nil, &resp)
+	err := c.getCached(ctx, force, c.apiBaseURL+withdrawDataPath, &resp)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get withdraw data")
 	}
@@ -302,7 +587,7 @@ type Auth2Response struct {
 	Token string `json:"token"`
 }
 
-func (c *lowLevelClient) auth2() (*Auth2Response, error) {
+func (c *lowLevelClient) auth2(ctx context.Context) (*Auth2Response, error) {
 	c.userTokenLock.RLock()
 	userToken := c.userToken
 	c.userTokenLock.RUnlock()
@@ -313,10 +598,13 @@ func (c *lowLevelClient) auth2() (*Auth2Response, error) {
 	}
 	var resp Auth2Response
 
-	err := c.do(http.MethodPost, true, hubAuth2URL, nil, req, &resp)
+	err := c.doContext(ctx, http.MethodPost, true, c.apiBaseURL+hubAuth2Path, nil, req, &resp)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to auth2")
 	}
+	if resp.Host == "" || resp.Token == "" {
+		return nil, ErrHubNotProvisioned
+	}
 
 	return &resp, nil
 }
@@ -324,8 +612,32 @@ func (c *lowLevelClient) auth2() (*Auth2Response, error) {
 // A MachinesResponse holds a bunch of MachineEntries.
 type MachinesResponse []MachineEntry
 
+func (r *MachinesResponse) unmarshalJSON(b []byte, strict bool) error {
+	var raw []json.RawMessage
+	err := decodeJSON(b, &raw, strict)
+	if err != nil {
+		return err
+	}
+
+	machines, err := unmarshalEach[MachineEntry](raw, strict)
+	if err != nil {
+		return errors.Wrap(err, "unable to decode machines")
+	}
+
+	*r = machines
+	return nil
+}
+
 // A MachineEntry holds information about one machine.
 // This is used by both the HTTP and Websocket API.
+//
+// The SID identifies the machine and is used to address it in Live API
+// messages.
+// The Key is a per-machine value that is not used by any request the website
+// makes, neither for authentication nor for controlling devices.
+// It is probably used by the miner itself to authenticate the machine against
+// the hub.
+// Treat it as a secret, it is redacted from debug logs.
 type MachineEntry struct {
 	MachineName   string        `json:"machineName"`
 	SID           string        `json:"sid"`
@@ -336,16 +648,67 @@ type MachineEntry struct {
 	Key           string        `json:"key"`
 }
 
+// UnmarshalJSON implements json.Unmarshaler.
+// The SID may be sent as a string or a number, it is always stored as a string.
+func (e *MachineEntry) UnmarshalJSON(b []byte) error {
+	return e.unmarshalJSON(b, false)
+}
+
+func (e *MachineEntry) unmarshalJSON(b []byte, strict bool) error {
+	type machineEntry MachineEntry
+	var v struct {
+		machineEntry
+		SID     FlexString        `json:"sid"`
+		Devices []json.RawMessage `json:"devices"`
+	}
+	err := decodeJSON(b, &v, strict)
+	if err != nil {
+		return err
+	}
+
+	devices, err := unmarshalEach[DeviceEntry](v.Devices, strict)
+	if err != nil {
+		return errors.Wrap(err, "unable to decode devices")
+	}
+
+	*e = MachineEntry(v.machineEntry)
+	e.SID = string(v.SID)
+	e.Devices = devices
+	return nil
+}
+
 // A DeviceEntry holds information about one device.
 // This is used for both the HTTP and the Websocket API.
 type DeviceEntry struct {
-	ID      string       `json:"id"` // probably string
+	ID      string       `json:"id"`
 	Enabled bool         `json:"enabled"`
 	Name    string       `json:"name"`
 	Type    string       `json:"type"`
 	Status  DeviceStatus `json:"status"`
 }
 
+// UnmarshalJSON implements json.Unmarshaler.
+// The ID may be sent as a string or a number, it is always stored as a string.
+func (e *DeviceEntry) UnmarshalJSON(b []byte) error {
+	return e.unmarshalJSON(b, false)
+}
+
+func (e *DeviceEntry) unmarshalJSON(b []byte, strict bool) error {
+	type deviceEntry DeviceEntry
+	var v struct {
+		deviceEntry
+		ID FlexString `json:"id"`
+	}
+	err := decodeJSON(b, &v, strict)
+	if err != nil {
+		return err
+	}
+
+	*e = DeviceEntry(v.deviceEntry)
+	e.ID = string(v.ID)
+	return nil
+}
+
 // DeviceStatus is the status of one device.
 // This is used for both the HTTP and the Websocket API.
 type DeviceStatus struct {
@@ -354,13 +717,13 @@ type DeviceStatus struct {
 	Hashrates []decimal.Decimal `json:"hashrates"`
 	Profits   []decimal.Decimal `json:"profits"`
 	Currency  string            `json:"currency"`
-	ExtraData string            `json:"extraData"` // never seen, no idea what type
+	ExtraData FlexString        `json:"extraData"` // never seen, no idea what type
 }
 
-func (c *lowLevelClient) getMachines() (*MachinesResponse, error) {
+func (c *lowLevelClient) getMachines(ctx context.Context, force bool) (*MachinesResponse, error) {
 	var resp MachinesResponse
 
-	err := c.do(http.MethodGet, true, machinesURL, nil, nil, &resp)
+	err := c.getCached(ctx, force, c.apiBaseURL+machinesPath, &resp)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get machines")
 	}
@@ -370,6 +733,11 @@ func (c *lowLevelClient) getMachines() (*MachinesResponse, error) {
 
 // An ExchangeRequest holds the data necessary to get coin exchange information.
 // Note that this is only used by the miner, not the website.
+//
+// There is no known way to derive the tokens from the LoginResponse or the
+// machine Key.
+// The miner application sends them, so they can only be obtained by capturing
+// the miner's request to the exchange endpoint, see WithExchangeTokens.
 type ExchangeRequest struct {
 	MiningToken  string `json:"MiningToken"`
 	BalanceToken string `json:"BalanceToken"`
@@ -387,7 +755,7 @@ func (c *lowLevelClient) getExchangeBalance(miningToken, balanceToken string) (*
 	}
 	var resp ExchangeResponse
 
-	err := c.do(http.MethodPost, false, exchangeURL, nil, req, &resp)
+	err := c.do(http.MethodPost, false, c.apiBaseURL+exchangePath, nil, req, &resp)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get exchange balance")
 	}
@@ -412,15 +780,59 @@ type StatEntry struct {
 	HashSec   int             `json:"hashSec"`
 }
 
+// UnmarshalJSON implements json.Unmarshaler.
+// The machine ID may be sent as a string or a number, it is always stored as a
+// string, like MachineEntry.SID.
+func (e *StatEntry) UnmarshalJSON(b []byte) error {
+	return e.unmarshalJSON(b, false)
+}
+
+func (e *StatEntry) unmarshalJSON(b []byte, strict bool) error {
+	type statEntry StatEntry
+	var v struct {
+		statEntry
+		MachineID FlexString `json:"machineId"`
+	}
+	err := decodeJSON(b, &v, strict)
+	if err != nil {
+		return err
+	}
+
+	*e = StatEntry(v.statEntry)
+	e.MachineID = string(v.MachineID)
+	return nil
+}
+
+func (r *StatsResponse) unmarshalJSON(b []byte, strict bool) error {
+	type statsResponse StatsResponse
+	var v struct {
+		statsResponse
+		Stats []json.RawMessage `json:"stats"`
+	}
+	err := decodeJSON(b, &v, strict)
+	if err != nil {
+		return err
+	}
+
+	stats, err := unmarshalEach[StatEntry](v.Stats, strict)
+	if err != nil {
+		return errors.Wrap(err, "unable to decode stats")
+	}
+
+	*r = StatsResponse(v.statsResponse)
+	r.Stats = stats
+	return nil
+}
+
 // ParseDate parses a date from the winminer string-encoding to a time.Time.
 func ParseDate(date string) (time.Time, error) {
 	return time.Parse(time.RFC3339, date)
 }
 
-func (c *lowLevelClient) getStats() (*StatsResponse, error) {
+func (c *lowLevelClient) getStats(ctx context.Context) (*StatsResponse, error) {
 	var resp StatsResponse
 
-	err := c.do(http.MethodGet, true, statsURL, nil, nil, &resp)
+	err := c.doContext(ctx, http.MethodGet, true, c.apiBaseURL+statsPath, nil, nil, &resp)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get stats")
 	}
@@ -448,12 +860,27 @@ func (c *lowLevelClient) postLogin(email, password string) (*LoginResponse, erro
 		Password:      password,
 		HubClientType: 200,
 	}
-	var resp LoginResponse
+	var raw json.RawMessage
 
-	err := c.do(http.MethodPost, false, loginURL, nil, req, &resp)
+	err := c.do(http.MethodPost, false, c.apiBaseURL+loginPath, nil, req, &raw)
 	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			if challenge, ok := parseChallenge(apiErr.StatusCode, []byte(apiErr.Body)); ok {
+				return nil, challenge
+			}
+		}
 		return nil, errors.Wrap(err, "unable to login")
 	}
+	if challenge, ok := parseChallenge(http.StatusOK, raw); ok {
+		return nil, challenge
+	}
+
+	var resp LoginResponse
+	err = c.decode(raw, &resp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decode login response (raw: %s)", redactBody(raw))
+	}
 
 	c.userTokenLock.Lock()
 	c.userToken = resp.UserToken
@@ -463,24 +890,78 @@ func (c *lowLevelClient) postLogin(email, password string) (*LoginResponse, erro
 }
 
 func (c *lowLevelClient) do(method string, withAuth bool, url string, params url.Values, request, response interface{}) error {
-	var body io.Reader
+	return c.doContext(context.Background(), method, withAuth, url, params, request, response)
+}
+
+func (c *lowLevelClient) doContext(ctx context.Context, method string, withAuth bool, url string, params url.Values, request, response interface{}) error {
+	var requestBody []byte
 	if request != nil {
 		b, err := json.Marshal(request)
 		if err != nil {
 			return errors.Wrap(err, "unable to encode request data")
 		}
-		body = bytes.NewReader(b)
+		requestBody = b
 	}
 
-	req, err := http.NewRequest(method, url, body)
+	// Only GETs are idempotent, everything else is tried exactly once.
+	attempts := 1
+	if method == http.MethodGet && c.retryAttempts > 1 {
+		attempts = c.retryAttempts
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := c.retryBaseDelay << uint(attempt-1)
+			if c.debug {
+				c.logger.WithFields(log.Fields{"url": url, "attempt": attempt + 1, "delay": delay, "err": err}).Debugln("retrying request")
+			}
+
+			t := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return errors.Wrap(ctx.Err(), "retry aborted")
+			case <-t.C:
+			}
+		}
+
+		var retryable bool
+		retryable, err = c.doOnce(ctx, method, withAuth, url, params, requestBody, response)
+		if err == nil || !retryable {
+			return err
+		}
+	}
+
+	return errors.Wrapf(err, "giving up after %d attempts", attempts)
+}
+
+// doOnce performs a single request.
+// It returns whether the request failed on the connection level and may thus
+// be retried.
+func (c *lowLevelClient) doOnce(ctx context.Context, method string, withAuth bool, url string, params url.Values, requestBody []byte, response interface{}) (retryable bool, err error) {
+	ctx, span := c.startSpan(ctx, spanName(method, url))
+	span.SetAttribute(AttrHTTPMethod, method)
+	span.SetAttribute(AttrHTTPURL, spanURL(url))
+	defer func(start time.Time) {
+		endSpan(span, start, err)
+	}(time.Now())
+
+	var body io.Reader
+	if requestBody != nil {
+		body = bytes.NewReader(requestBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		return errors.Wrap(err, "unable to construct request")
+		return false, errors.Wrap(err, "unable to construct request")
 	}
+	c.applyHeaders(req.Header)
 	if withAuth {
 		c.userTokenLock.RLock()
 		userToken := c.userToken
 		c.userTokenLock.RUnlock()
-		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", userToken))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", userToken))
 	}
 	if body != nil {
 		req.Header.Set("Content-Type", jsonContentType)
@@ -490,31 +971,319 @@ func (c *lowLevelClient) do(method string, withAuth bool, url string, params url
 	}
 
 	if c.debug {
-		log.WithFields(log.Fields{"method": method, "withAuth": withAuth, "url": url, "params": params, "request": request}).Debugln("performing request")
+		c.logger.WithFields(log.Fields{"method": method, "withAuth": withAuth, "url": url, "params": redactParams(params), "header": redactHeader(req.Header), "request": redactJSON(requestBody)}).Debugln("performing request")
 	}
 
 	resp, err := c.c.Do(req)
 	if err != nil {
-		return errors.Wrap(err, "unable to perform request")
+		return ctx.Err() == nil, errors.Wrap(redactURLError(err), "unable to perform request")
 	}
 	defer resp.Body.Close()
+	span.SetAttribute(AttrHTTPStatusCode, resp.StatusCode)
+
+	var respBody io.Reader = resp.Body
+	if c.responseSizeLimit > 0 {
+		respBody = &limitedReader{r: resp.Body, remaining: c.responseSizeLimit}
+	}
+
+	if c.streamDecode && !c.debug && c.isMaintenance == nil && method == http.MethodGet && resp.StatusCode == 200 {
+		err = c.decodeStream(respBody, response)
+		if err != nil {
+			return errors.Is(err, ErrTruncatedResponse), err
+		}
+		return false, nil
+	}
 
-	b, err := ioutil.ReadAll(resp.Body)
+	b, err := ioutil.ReadAll(respBody)
+	if errors.Is(err, ErrResponseTooLarge) {
+		return false, err
+	}
 	if err != nil {
-		return errors.Wrap(err, "unable to read response body")
+		return ctx.Err() == nil, errors.Wrap(err, "unable to read response body")
 	}
 	if c.debug {
-		log.WithFields(log.Fields{"statusCode": resp.StatusCode, "status": resp.Status, "body": string(b)}).Debugln("got response")
+		c.logger.WithFields(log.Fields{"statusCode": resp.StatusCode, "status": resp.Status, "body": redactJSON(b)}).Debugln("got response")
 	}
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("server returned status %d: %s, body %s", resp.StatusCode, resp.Status, string(b))
+		return false, &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(b)}
+	}
+
+	if c.isMaintenance != nil && c.isMaintenance(b) {
+		return false, ErrMaintenance
+	}
+
+	err = c.decode(b, response)
+	if isTruncatedJSON(err) {
+		return true, &TruncatedResponseError{Received: int64(len(b))}
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to decode response (raw: %s)", redactBody(b))
+	}
+
+	return false, nil
+}
+
+// isTruncatedJSON returns whether err was returned by decoding JSON that
+// ended prematurely.
+func isTruncatedJSON(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr) && syntaxErr.Error() == "unexpected end of JSON input"
+}
+
+// A limitedReader reads from r until more than remaining bytes have been
+// read, after which it fails with ErrResponseTooLarge.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// A countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// applyHeaders adds the static headers configured via WithHTTPHeaders to h.
+// Headers set by the client itself must be set afterwards, to take
+// precedence.
+func (c *lowLevelClient) applyHeaders(h http.Header) {
+	for key, values := range c.headers {
+		for _, value := range values {
+			h.Add(key, value)
+		}
+	}
+}
+
+// decode decodes a JSON response.
+// In strict mode, unknown fields cause an error.
+func (c *lowLevelClient) decode(b []byte, response interface{}) error {
+	if s, ok := response.(strictUnmarshaler); ok {
+		return s.unmarshalJSON(b, c.strictJSON)
 	}
 
-	err = json.Unmarshal(b, response)
+	return decodeJSON(b, response, c.strictJSON)
+}
+
+// decodeJSON decodes b into v.
+// If strict is set, unknown fields cause an error.
+func decodeJSON(b []byte, v interface{}, strict bool) error {
+	if !strict {
+		return json.Unmarshal(b, v)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// A strictUnmarshaler is a type with custom JSON decoding that can reject
+// unknown fields.
+// json.Decoder.DisallowUnknownFields does not apply to types implementing
+// json.Unmarshaler, so these types, and the responses containing them, pass
+// strict mode on themselves.
+type strictUnmarshaler interface {
+	unmarshalJSON(b []byte, strict bool) error
+}
+
+// unmarshalEach decodes every element of raw as a T.
+// A nil raw results in a nil slice.
+func unmarshalEach[T any, PT interface {
+	*T
+	strictUnmarshaler
+}](raw []json.RawMessage, strict bool) ([]T, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	elems := make([]T, len(raw))
+	for i, b := range raw {
+		err := PT(&elems[i]).unmarshalJSON(b, strict)
+		if err != nil {
+			return nil, errors.Wrapf(err, "element %d", i)
+		}
+	}
+
+	return elems, nil
+}
+
+// maxStreamErrorContext is the maximum number of bytes of the remaining
+// response body included in decoding errors when streaming.
+const maxStreamErrorContext = 4096
+
+// decodeStream decodes a JSON response directly from the response body,
+// without buffering it.
+// If decoding fails, the rest of the body is read to provide some context.
+func (c *lowLevelClient) decodeStream(body io.Reader, response interface{}) error {
+	if s, ok := response.(strictUnmarshaler); ok && c.strictJSON {
+		var raw json.RawMessage
+		err := c.decodeStream(body, &raw)
+		if err != nil {
+			return err
+		}
+		return s.unmarshalJSON(raw, true)
+	}
+
+	counter := &countingReader{r: body}
+	dec := json.NewDecoder(counter)
+	if c.strictJSON {
+		dec.DisallowUnknownFields()
+	}
+
+	err := dec.Decode(response)
+	if isTruncatedJSON(err) {
+		return &TruncatedResponseError{Received: counter.n}
+	}
 	if err != nil {
-		return errors.Wrapf(err, "unable to decode response (raw: %s)", string(b))
+		rest, _ := ioutil.ReadAll(io.LimitReader(io.MultiReader(dec.Buffered(), body), maxStreamErrorContext))
+		return errors.Wrapf(err, "unable to decode response (remaining: %s)", string(rest))
 	}
 
 	return nil
 }
+
+// redacted replaces sensitive values in debug output.
+const redacted = "***"
+
+// sensitiveKeys are the JSON keys and query parameters whose values are never
+// logged.
+var sensitiveKeys = map[string]struct{}{
+	"password":        {},
+	"usertoken":       {},
+	"logintoken":      {},
+	"hubtoken":        {},
+	"connectiontoken": {},
+	"token":           {},
+	"key":             {},
+}
+
+func isSensitive(key string) bool {
+	_, ok := sensitiveKeys[strings.ToLower(key)]
+	return ok
+}
+
+// redactParams returns a copy of params with sensitive values replaced.
+func redactParams(params url.Values) url.Values {
+	if params == nil {
+		return nil
+	}
+
+	v := make(url.Values, len(params))
+	for key, values := range params {
+		if isSensitive(key) {
+			v[key] = []string{redacted}
+			continue
+		}
+		v[key] = values
+	}
+
+	return v
+}
+
+// redactHeader returns a copy of h with the Authorization value replaced.
+func redactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	if h.Get("Authorization") != "" {
+		h.Set("Authorization", redacted)
+	}
+
+	return h
+}
+
+// redactJSON returns b as a string, with the values of sensitive keys
+// replaced.
+// If b is not valid JSON, it is returned unchanged.
+func redactJSON(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+
+	var v interface{}
+	err := json.Unmarshal(b, &v)
+	if err != nil {
+		return string(b)
+	}
+
+	bb, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return string(b)
+	}
+
+	return string(bb)
+}
+
+// redactBody is like redactJSON, but does not return invalid JSON, which may
+// contain sensitive values that could not be redacted.
+// Use it to include response bodies in errors.
+func redactBody(b []byte) string {
+	if len(b) != 0 && !json.Valid(b) {
+		return fmt.Sprintf("%d bytes of invalid JSON", len(b))
+	}
+
+	return redactJSON(b)
+}
+
+// redactURLError returns err with sensitive query parameters replaced, if it
+// is a *url.Error, whose message includes the URL of the request.
+func redactURLError(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+
+	u, parseErr := url.Parse(urlErr.URL)
+	if parseErr != nil {
+		return &url.Error{Op: urlErr.Op, URL: redacted, Err: urlErr.Err}
+	}
+	u.RawQuery = redactParams(u.Query()).Encode()
+
+	return &url.Error{Op: urlErr.Op, URL: u.String(), Err: urlErr.Err}
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, value := range t {
+			if isSensitive(key) {
+				t[key] = redacted
+				continue
+			}
+			t[key] = redactValue(value)
+		}
+	case []interface{}:
+		for i, value := range t {
+			t[i] = redactValue(value)
+		}
+	}
+
+	return v
+}
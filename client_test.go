@@ -0,0 +1,113 @@
+package winminer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeNumericIDs(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		c := &lowLevelClient{strictJSON: strict}
+
+		var machines MachinesResponse
+		err := c.decode([]byte(`[{"machineName":"rig","sid":123,"devices":[{"id":4,"name":"GPU"},{"id":"5"}]}]`), &machines)
+		if err != nil {
+			t.Fatalf("strict=%v: unable to decode machines: %s", strict, err)
+		}
+		if len(machines) != 1 || machines[0].SID != "123" || machines[0].MachineName != "rig" {
+			t.Fatalf("strict=%v: unexpected machines %+v", strict, machines)
+		}
+		devices := machines[0].Devices
+		if len(devices) != 2 || devices[0].ID != "4" || devices[0].Name != "GPU" || devices[1].ID != "5" {
+			t.Errorf("strict=%v: unexpected devices %+v", strict, devices)
+		}
+
+		var stats StatsResponse
+		err = c.decode([]byte(`{"stats":[{"machineId":123,"hashSec":5}],"balance":"1.5"}`), &stats)
+		if err != nil {
+			t.Fatalf("strict=%v: unable to decode stats: %s", strict, err)
+		}
+		if len(stats.Stats) != 1 || stats.Stats[0].MachineID != "123" || stats.Stats[0].HashSec != 5 || stats.Balance.String() != "1.5" {
+			t.Errorf("strict=%v: unexpected stats %+v", strict, stats)
+		}
+	}
+}
+
+func TestDecodeStrictUnknownFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		b        string
+		response interface{}
+	}{
+		{"machine", `[{"sid":"1","unknown":true}]`, &MachinesResponse{}},
+		{"device", `[{"sid":"1","devices":[{"id":"2","unknown":true}]}]`, &MachinesResponse{}},
+		{"device status", `[{"sid":"1","devices":[{"id":"2","status":{"unknown":true}}]}]`, &MachinesResponse{}},
+		{"stats", `{"stats":[],"unknown":true}`, &StatsResponse{}},
+		{"stat entry", `{"stats":[{"machineId":1,"unknown":true}]}`, &StatsResponse{}},
+	}
+
+	for _, test := range tests {
+		lenient := &lowLevelClient{}
+		err := lenient.decode([]byte(test.b), test.response)
+		if err != nil {
+			t.Errorf("%s: lenient decoding failed: %s", test.name, err)
+		}
+
+		strict := &lowLevelClient{strictJSON: true}
+		err = strict.decode([]byte(test.b), test.response)
+		if err == nil {
+			t.Errorf("%s: strict decoding accepted an unknown field", test.name)
+		}
+	}
+}
+
+func TestRequestErrorsAreRedacted(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	bodies := []string{
+		`{"userToken":["secret"],"hubToken":"secret"}`,
+		`{"userToken":"secret"}}`,
+	}
+	for _, cache := range []bool{false, true} {
+		opts := []Option{WithBaseURL(srv.URL)}
+		if cache {
+			opts = append(opts, WithCache(time.Minute))
+		}
+		c := newAPIClient("", "", false, opts)
+
+		for i, b := range bodies {
+			body = b
+			var resp LoginResponse
+			err := c.c.getCached(context.Background(), true, srv.URL+"/"+string(rune('a'+i)), &resp)
+			if err == nil {
+				t.Errorf("cache=%v: expected decoding %s to fail", cache, b)
+				continue
+			}
+			if strings.Contains(err.Error(), "secret") {
+				t.Errorf("cache=%v: error leaks a token: %s", cache, err)
+			}
+		}
+	}
+
+	// Connection errors include the URL.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	c := newAPIClient("", "", false, nil)
+	params := url.Values{"token": {"secret"}, "connectionToken": {"secret"}, "transport": {"webSockets"}}
+	_, err := c.c.doOnce(context.Background(), http.MethodGet, false, closed.URL+"/signalr/poll", params, nil, nil)
+	if err == nil {
+		t.Fatal("expected the request to fail")
+	}
+	if strings.Contains(err.Error(), "secret") || !strings.Contains(err.Error(), "transport=webSockets") {
+		t.Errorf("expected the URL to be redacted, got %s", err)
+	}
+}
@@ -1,85 +1,220 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"os"
 
 	"github.com/mrd0ll4r/winminer"
 	log "github.com/sirupsen/logrus"
 )
 
+type command struct {
+	name        string
+	description string
+	run         func(client *winminer.APIClient) error
+}
+
+var commands = []command{
+	{"stats", "print historical statistics", runStats},
+	{"machines", "print machines and their devices", runMachines},
+	{"withdraw-data", "print current withdraw options", runWithdrawData},
+	{"withdraw-history", "print the withdraw history", runWithdrawHistory},
+	{"watch", "watch the live API and print status changes", runWatch},
+	{"tail", "print all live API events as JSON lines", runTail},
+}
+
+// jsonOutput controls whether output is printed as JSON.
+var jsonOutput bool
+
+// output prints v, either as indented JSON or using the %+v verb.
+func output(v interface{}) error {
+	if !jsonOutput {
+		fmt.Printf("%+v\n", v)
+		return nil
+	}
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <command>\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-18s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nCredentials are read from %s and %s, or ~/%s, which must only be accessible by you.\n\nFlags:\n", winminer.EnvEmail, winminer.EnvPassword, winminer.CredentialsFile)
+	flag.PrintDefaults()
+}
+
 func main() {
-	log.SetLevel(log.DebugLevel)
+	debug := flag.Bool("debug", false, "enable debug logging")
+	flag.BoolVar(&jsonOutput, "json", false, "print responses as indented JSON")
+	flag.Usage = usage
+	flag.Parse()
 
-	fmt.Println("connecting...")
-	client, err := winminer.NewAPIClient("you@example.com", "password", true)
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == flag.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	email, password, err := winminer.LoadCredentials()
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
-	fmt.Println("connected.")
 
+	client, err := winminer.NewAPIClient(email, password, *debug)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = cmd.run(client)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func runStats(client *winminer.APIClient) error {
 	stats, err := client.GetStats()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Printf("%+v\n", stats)
+	return output(stats)
+}
 
+func runMachines(client *winminer.APIClient) error {
 	machines, err := client.GetMachines()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Printf("%+v\n", machines)
+	return output(machines)
+}
 
+func runWithdrawData(client *winminer.APIClient) error {
 	withdrawData, err := client.GetWithdrawData()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Printf("%+v\n", withdrawData)
+	return output(withdrawData)
+}
 
+func runWithdrawHistory(client *winminer.APIClient) error {
 	withdrawHistory, err := client.GetWithdrawHistory()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Printf("%+v\n", withdrawHistory)
-
-	ltc, err := withdrawHistory.Transactions[0].ParseDataAsLitecoinTransaction()
+	err = output(withdrawHistory)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	fmt.Printf("%+v\n", ltc)
 
+	for _, t := range withdrawHistory.Transactions {
+		if t.TransactionType != winminer.TransactionTypeLitecoin {
+			continue
+		}
+
+		ltc, err := t.ParseDataAsLitecoinTransaction()
+		if err != nil {
+			return err
+		}
+		err = output(ltc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runWatch(client *winminer.APIClient) error {
 	ws, err := client.ConnectWebsocket()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer client.CloseWebsocket()
 
 	state := winminer.NewLiveState()
 
-	for i := 0; i < 10; i++ {
+	for {
 		messages, err := ws.ReadNextInterestingMessages()
 		if err != nil {
-			panic(err)
+			log.WithField("err", err).Errorln("websocket broken, reconnecting")
+			ws, err = client.ReconnectWebsocket()
+			if err != nil {
+				return err
+			}
+			continue
 		}
 
 		for _, msg := range messages.Messages {
-			fmt.Printf("%+v\n", msg)
 			switch msg.Method {
 			case winminer.MethodSetSystemInfo:
 				sysInf, err := winminer.ParseSystemInfoMessage(msg)
 				if err != nil {
-					panic(err)
+					return err
 				}
 
 				state.SetSystemInfo(sysInf)
+				err = output(sysInf)
+				if err != nil {
+					return err
+				}
 			case winminer.MethodStatusChanged:
 				status, err := winminer.ParseStatusChangedMessage(msg)
 				if err != nil {
-					panic(err)
+					return err
 				}
 				err = state.UpdateStatus(*status)
 				if err != nil {
-					panic(err)
+					return err
+				}
+				err = output(status)
+				if err != nil {
+					return err
+				}
+			case winminer.MethodStateChanged:
+				stateChange, err := winminer.ParseStateChangedMessage(msg)
+				if err != nil {
+					return err
+				}
+				err = state.UpdateState(*stateChange)
+				if err != nil {
+					return err
+				}
+				err = output(stateChange)
+				if err != nil {
+					return err
 				}
 			}
 		}
 	}
 }
+
+func runTail(client *winminer.APIClient) error {
+	ws, err := client.ConnectWebsocket()
+	if err != nil {
+		return err
+	}
+	defer client.CloseWebsocket()
+
+	return ws.Tail(context.Background(), os.Stdout)
+}
@@ -0,0 +1,88 @@
+package winminer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// Environment variables read by LoadCredentials.
+const (
+	EnvEmail    = "WINMINER_EMAIL"
+	EnvPassword = "WINMINER_PASSWORD"
+)
+
+// CredentialsFile is the path of the credentials file read by
+// LoadCredentials, relative to the user's home directory.
+var CredentialsFile = filepath.Join(".winminer", "credentials")
+
+// Credentials are the contents of the credentials file.
+type Credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// LoadCredentials loads the email and password to log in with.
+// The credentials are taken from the EnvEmail and EnvPassword environment
+// variables, if both are set.
+// Otherwise, they are read from the CredentialsFile in the user's home
+// directory, which holds a JSON-encoded Credentials object.
+// Like ssh, the file is refused with ErrInsecureCredentials if its group or
+// other users have any permissions on it, i.e., its mode must be 0600 or
+// stricter. This is not checked on Windows.
+//
+// The OS keyring is not supported, as that would require platform-specific
+// dependencies.
+//
+// If no credentials can be found, ErrNoCredentials is returned.
+func LoadCredentials() (email, password string, err error) {
+	email, password = os.Getenv(EnvEmail), os.Getenv(EnvPassword)
+	if email != "" && password != "" {
+		return email, password, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to determine home directory")
+	}
+
+	path := filepath.Join(home, CredentialsFile)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", ErrNoCredentials
+		}
+		return "", "", errors.Wrap(err, "unable to open credentials file")
+	}
+	defer f.Close()
+
+	if runtime.GOOS != "windows" {
+		fi, err := f.Stat()
+		if err != nil {
+			return "", "", errors.Wrap(err, "unable to stat credentials file")
+		}
+		if perm := fi.Mode().Perm(); perm&0077 != 0 {
+			return "", "", errors.Wrapf(ErrInsecureCredentials, "%s has mode %#o, expected 0600", path, perm)
+		}
+	}
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to read credentials file")
+	}
+
+	var c Credentials
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return "", "", errors.Wrap(err, "unable to parse credentials file")
+	}
+	if c.Email == "" || c.Password == "" {
+		return "", "", errors.Wrap(ErrNoCredentials, "credentials file incomplete")
+	}
+
+	return c.Email, c.Password, nil
+}
@@ -0,0 +1,72 @@
+package winminer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	tests := []struct {
+		name         string
+		envEmail     string
+		envPassword  string
+		file         string // not written if empty
+		mode         os.FileMode
+		wantEmail    string
+		wantPassword string
+		wantErr      error // nil for any error if wantEmail is empty
+	}{
+		{"environment", "env@example.com", "env", `{"email":"file@example.com","password":"file"}`, 0600, "env@example.com", "env", nil},
+		{"environment without file", "env@example.com", "env", "", 0, "env@example.com", "env", nil},
+		{"file", "", "", `{"email":"file@example.com","password":"file"}`, 0600, "file@example.com", "file", nil},
+		{"file if only email is set", "env@example.com", "", `{"email":"file@example.com","password":"file"}`, 0600, "file@example.com", "file", nil},
+		{"file if only password is set", "", "env", `{"email":"file@example.com","password":"file"}`, 0400, "file@example.com", "file", nil},
+		{"nothing", "", "", "", 0, "", "", ErrNoCredentials},
+		{"incomplete file", "", "", `{"email":"file@example.com"}`, 0600, "", "", ErrNoCredentials},
+		{"invalid file", "", "", `{"email":`, 0600, "", "", nil},
+		{"group readable", "", "", `{"email":"file@example.com","password":"file"}`, 0640, "", "", ErrInsecureCredentials},
+		{"world readable", "", "", `{"email":"file@example.com","password":"file"}`, 0604, "", "", ErrInsecureCredentials},
+	}
+
+	for _, test := range tests {
+		if runtime.GOOS == "windows" && errors.Is(test.wantErr, ErrInsecureCredentials) {
+			continue
+		}
+
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		t.Setenv("USERPROFILE", home)
+		t.Setenv(EnvEmail, test.envEmail)
+		t.Setenv(EnvPassword, test.envPassword)
+		if test.file != "" {
+			path := filepath.Join(home, CredentialsFile)
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(test.file), test.mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, test.mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		email, password, err := LoadCredentials()
+		if test.wantEmail == "" {
+			if err == nil || (test.wantErr != nil && !errors.Is(err, test.wantErr)) {
+				t.Errorf("%s: expected error %v, got %v", test.name, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if email != test.wantEmail || password != test.wantPassword {
+			t.Errorf("%s: expected %s/%s, got %s/%s", test.name, test.wantEmail, test.wantPassword, email, password)
+		}
+	}
+}
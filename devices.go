@@ -0,0 +1,173 @@
+package winminer
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// DeviceTelemetry holds telemetry of a device, as contained in
+// DeviceStatus.ExtraData.
+// Fields that were not present are nil.
+type DeviceTelemetry struct {
+	Temperature *decimal.Decimal `json:"temperature"` // °C
+	FanPercent  *decimal.Decimal `json:"fan"`
+	PowerWatts  *decimal.Decimal `json:"power"`
+	MemoryClock *decimal.Decimal `json:"memoryClock"` // MHz
+
+	// Raw holds all fields of the extra data, including unknown ones.
+	Raw map[string]json.RawMessage `json:"-"`
+}
+
+// telemetryKeys maps the field names we expect in the extra data,
+// lowercased, to the telemetry fields.
+var telemetryKeys = map[string]func(t *DeviceTelemetry) **decimal.Decimal{
+	"temp":        func(t *DeviceTelemetry) **decimal.Decimal { return &t.Temperature },
+	"temperature": func(t *DeviceTelemetry) **decimal.Decimal { return &t.Temperature },
+	"fan":         func(t *DeviceTelemetry) **decimal.Decimal { return &t.FanPercent },
+	"fanspeed":    func(t *DeviceTelemetry) **decimal.Decimal { return &t.FanPercent },
+	"power":       func(t *DeviceTelemetry) **decimal.Decimal { return &t.PowerWatts },
+	"powerusage":  func(t *DeviceTelemetry) **decimal.Decimal { return &t.PowerWatts },
+	"memclock":    func(t *DeviceTelemetry) **decimal.Decimal { return &t.MemoryClock },
+	"memoryclock": func(t *DeviceTelemetry) **decimal.Decimal { return &t.MemoryClock },
+}
+
+// ParseExtraData attempts to decode the ExtraData of a device status as
+// telemetry.
+// The format of the extra data is not documented, so a few likely field names
+// are tried.
+// If the extra data is empty or not a JSON object, ErrNoTelemetry is
+// returned.
+func (s DeviceStatus) ParseExtraData() (*DeviceTelemetry, error) {
+	if strings.TrimSpace(string(s.ExtraData)) == "" {
+		return nil, ErrNoTelemetry
+	}
+
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal([]byte(s.ExtraData), &raw)
+	if err != nil {
+		return nil, ErrNoTelemetry
+	}
+
+	t := DeviceTelemetry{Raw: raw}
+	for key, value := range raw {
+		field, ok := telemetryKeys[strings.ToLower(key)]
+		if !ok {
+			continue
+		}
+
+		var d decimal.Decimal
+		if json.Unmarshal(value, &d) == nil {
+			*field(&t) = &d
+		}
+	}
+
+	return &t, nil
+}
+
+// DevicesByType returns the devices of the machine with the given type, e.g.
+// GPU.
+// Types are compared case-insensitively.
+func (m MachineEntry) DevicesByType(t string) []DeviceEntry {
+	var devices []DeviceEntry
+	for _, d := range m.Devices {
+		if strings.EqualFold(d.Type, t) {
+			devices = append(devices, d)
+		}
+	}
+
+	return devices
+}
+
+// DevicesByType returns the devices of all machines with the given type, see
+// MachineEntry.DevicesByType.
+func (r MachinesResponse) DevicesByType(t string) []DeviceEntry {
+	var devices []DeviceEntry
+	for _, m := range r {
+		devices = append(devices, m.DevicesByType(t)...)
+	}
+
+	return devices
+}
+
+// hashrateUnits maps the known hashrate units, lowercased, to their canonical
+// spelling and their factor to H/s.
+var hashrateUnits = map[string]struct {
+	unit   string
+	factor decimal.Decimal
+}{
+	"h/s":  {"H/s", decimal.New(1, 0)},
+	"kh/s": {"kH/s", decimal.New(1, 3)},
+	"mh/s": {"MH/s", decimal.New(1, 6)},
+	"gh/s": {"GH/s", decimal.New(1, 9)},
+	"th/s": {"TH/s", decimal.New(1, 12)},
+}
+
+// hashrateUnit returns the lowercased hashrate unit of the status, as
+// contained in its tags or in a unit or hashrateUnit field of its extra data.
+func (s DeviceStatus) hashrateUnit() (string, bool) {
+	for _, tag := range s.Tags {
+		unit := strings.ToLower(strings.TrimSpace(tag))
+		if _, ok := hashrateUnits[unit]; ok {
+			return unit, true
+		}
+	}
+
+	var extra map[string]json.RawMessage
+	if json.Unmarshal([]byte(s.ExtraData), &extra) != nil {
+		return "", false
+	}
+	for key, value := range extra {
+		key = strings.ToLower(key)
+		if key != "unit" && key != "hashrateunit" {
+			continue
+		}
+
+		var unit string
+		if json.Unmarshal(value, &unit) != nil {
+			continue
+		}
+		unit = strings.ToLower(strings.TrimSpace(unit))
+		if _, ok := hashrateUnits[unit]; ok {
+			return unit, true
+		}
+	}
+
+	return "", false
+}
+
+// totalHashrate returns the sum of the hashrates of the status.
+func (s DeviceStatus) totalHashrate() decimal.Decimal {
+	total := decimal.Zero
+	for _, h := range s.Hashrates {
+		total = total.Add(h)
+	}
+
+	return total
+}
+
+// NormalizedHashrate returns the total hashrate of the status and its unit,
+// e.g. MH/s, if the unit is reported in the tags or the extra data.
+// The unit is empty if it is unknown.
+func (s DeviceStatus) NormalizedHashrate() (decimal.Decimal, string) {
+	unit, ok := s.hashrateUnit()
+	if !ok {
+		return s.totalHashrate(), ""
+	}
+
+	return s.totalHashrate(), hashrateUnits[unit].unit
+}
+
+// HashrateInHPerSec returns the total hashrate of the status in H/s, see
+// NormalizedHashrate.
+// Returns false if the unit is unknown, as hashrates in different units can
+// not be compared or summed.
+func (s DeviceStatus) HashrateInHPerSec() (decimal.Decimal, bool) {
+	unit, ok := s.hashrateUnit()
+	if !ok {
+		return decimal.Zero, false
+	}
+
+	return s.totalHashrate().Mul(hashrateUnits[unit].factor), true
+}
@@ -0,0 +1,137 @@
+package winminer_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mrd0ll4r/winminer"
+	"github.com/mrd0ll4r/winminer/winminertest"
+)
+
+// readAndApply reads the next interesting container and applies it to state.
+func readAndApply(t *testing.T, ws *winminer.WebsocketClient, state *winminer.LiveState) []winminer.StateChange {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container, err := ws.ReadNextInterestingMessagesContext(ctx)
+	if err != nil {
+		t.Fatalf("unable to read: %s", err)
+	}
+	changes, err := state.ApplyWithChanges(container)
+	if err != nil {
+		t.Fatalf("unable to apply: %s", err)
+	}
+
+	return changes
+}
+
+func TestEndToEnd(t *testing.T) {
+	const hubName = "testhub"
+
+	s := winminertest.NewServer()
+	defer s.Close()
+	s.SetHubName(hubName)
+	s.SetMachines(winminer.MachinesResponse{{
+		MachineName: "rig",
+		SID:         "sid",
+		Devices: []winminer.DeviceEntry{
+			{ID: "gpu0", Enabled: true, Status: winminer.DeviceStatus{Status: winminer.StatusStopping}},
+			{ID: "gpu1", Enabled: true, Status: winminer.DeviceStatus{Status: winminer.StatusStopping}},
+		},
+	}})
+	c := newTestClient(t, s, winminer.WithHubName(hubName))
+
+	// Initial state via HTTP.
+	machines, err := c.RefreshMachines()
+	if err != nil {
+		t.Fatalf("unable to get machines: %s", err)
+	}
+	state := winminer.NewLiveState()
+	state.SetSystemInfo(*machines)
+
+	// Connect and apply pushed messages.
+	ws, err := c.ConnectWebsocket()
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	if !ws.Initialized() {
+		t.Errorf("expected the connection to be initialized")
+	}
+	if ws.ConnectionID() != winminertest.ConnectionID {
+		t.Errorf("expected connection ID %q, got %q", winminertest.ConnectionID, ws.ConnectionID())
+	}
+
+	err = s.PushMessages(
+		s.Message(winminer.MethodStatusChanged, "sid", "gpu0", winminer.DeviceStatus{Status: winminer.StatusMining}),
+		s.Message(winminer.MethodStateChanged, "sid", "gpu1", false),
+	)
+	if err != nil {
+		t.Fatalf("unable to push: %s", err)
+	}
+	changes := readAndApply(t, ws, state)
+	if len(changes) != 2 {
+		t.Errorf("expected 2 changes, got %v", changes)
+	}
+	if mining := state.MiningDevices(); len(mining) != 1 || mining[0].DeviceID != "gpu0" {
+		t.Errorf("expected gpu0 to be mining, got %v", mining)
+	}
+	if enabled, ok := state.IsDeviceEnabled("gpu1"); !ok || enabled {
+		t.Errorf("expected gpu1 to be disabled, got enabled=%v ok=%v", enabled, ok)
+	}
+
+	// Invoke a hub method, which the server acknowledges.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = ws.Invoke(ctx, "Echo", "hello")
+	if err != nil {
+		t.Fatalf("unable to invoke: %s", err)
+	}
+	var inv struct {
+		Hub    string        `json:"H"`
+		Method string        `json:"M"`
+		Args   []interface{} `json:"A"`
+	}
+	received := s.Received()
+	if len(received) == 0 || json.Unmarshal(received[len(received)-1], &inv) != nil {
+		t.Fatalf("invocation not received, got %q", received)
+	}
+	if inv.Hub != hubName || inv.Method != "Echo" || len(inv.Args) != 1 || inv.Args[0] != "hello" {
+		t.Errorf("unexpected invocation %+v", inv)
+	}
+
+	// Disconnect, reconnect and keep applying messages.
+	s.Disconnect()
+	_, err = ws.ReadNextInterestingMessages()
+	if err == nil {
+		t.Fatalf("expected reading to fail after the server disconnected")
+	}
+
+	ws, err = c.ReconnectWebsocket()
+	if err != nil {
+		t.Fatalf("unable to reconnect: %s", err)
+	}
+	err = s.PushMessages(s.Message(winminer.MethodStatusChanged, "sid", "gpu0", winminer.DeviceStatus{Status: winminer.StatusStopping}))
+	if err != nil {
+		t.Fatalf("unable to push: %s", err)
+	}
+	readAndApply(t, ws, state)
+	if !state.AllStopped() {
+		t.Errorf("expected all devices to be stopped after reconnecting")
+	}
+}
+
+func TestServerRejectsOtherHubs(t *testing.T) {
+	s := winminertest.NewServer()
+	defer s.Close()
+	s.SetHubName("testhub")
+	c := newTestClient(t, s)
+
+	_, err := c.ConnectWebsocket()
+	if err == nil {
+		t.Fatal("expected connecting to the default hub to fail")
+	}
+}
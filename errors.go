@@ -0,0 +1,248 @@
+package winminer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned by the package.
+// Use errors.Is to check for them, as they are usually wrapped.
+var (
+	// ErrWebsocketClosed is returned when a WebsocketClient is used after it
+	// has been closed.
+	ErrWebsocketClosed = errors.New("websocket closed")
+
+	// ErrNoCredentials is returned by LoadCredentials if no credentials
+	// could be found.
+	ErrNoCredentials = errors.New("no credentials found")
+
+	// ErrNoTelemetry is returned by DeviceStatus.ParseExtraData if the
+	// extra data does not contain telemetry.
+	ErrNoTelemetry = errors.New("no telemetry")
+
+	// ErrHubNotProvisioned is returned when connecting to the Live API if
+	// the server did not return a hub host or token, which happens for
+	// accounts that are not provisioned for the hub, e.g., new accounts.
+	ErrHubNotProvisioned = errors.New("account not provisioned for the hub")
+
+	// ErrMachineNotFound is returned if a machine with the given SID does
+	// not exist.
+	ErrMachineNotFound = errors.New("machine not found")
+
+	// ErrInsecureCredentials is returned by LoadCredentials if the
+	// credentials file is accessible by other users.
+	ErrInsecureCredentials = errors.New("credentials file accessible by other users")
+
+	// ErrInvalidCredentials is returned by NewAPIClientWithToken if the
+	// server rejected the token.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrNoExchangeTokens is returned by GetExchangeBalance if no exchange
+	// tokens have been configured via WithExchangeTokens.
+	ErrNoExchangeTokens = errors.New("no exchange tokens configured")
+
+	// ErrMaintenance is returned if the server responded with a maintenance
+	// notice instead of the requested data, see WithMaintenanceDetector.
+	ErrMaintenance = errors.New("server is under maintenance")
+
+	// ErrChallengeRequired is matched by a ChallengeError, which is returned
+	// if the server asks to solve a challenge, e.g. a CAPTCHA, instead of
+	// logging in.
+	ErrChallengeRequired = errors.New("login challenge required")
+
+	// ErrTruncatedResponse is matched by a TruncatedResponseError, which is
+	// returned if a response body ended before the JSON it contains was
+	// complete.
+	ErrTruncatedResponse = errors.New("truncated response")
+
+	// ErrServerSilent is returned by reads of a Live API connection that
+	// was closed because nothing was received for too long, see
+	// WithSilenceWatchdog.
+	ErrServerSilent = errors.New("server went silent")
+
+	// ErrResponseTooLarge is returned if a response body exceeds the limit
+	// set via WithResponseSizeLimit.
+	ErrResponseTooLarge = errors.New("response too large")
+
+	// ErrFrameTooLarge is returned by reads of a Live API connection that
+	// was closed because a frame exceeded the limit set via
+	// WithMaxFrameSize.
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrNoSetStateMethod is returned by SetDeviceEnabled and
+	// SetMachineEnabled if no hub method was set via WithSetStateMethod.
+	ErrNoSetStateMethod = errors.New("no hub method to set device states")
+
+	// ErrAuthExpired is returned by reads of a Live API connection that was
+	// closed because the server rejected its auth2 token, see
+	// WebsocketClient.RefreshAuth2.
+	ErrAuthExpired = errors.New("auth2 token expired")
+)
+
+// An APIError is returned if the server responded with a non-200 status code.
+// Use errors.As to access it.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned status %d: %s, body %s", e.StatusCode, e.Status, e.Body)
+}
+
+// isAuthError returns whether err is an APIError with status 401 or 403.
+func isAuthError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// An authExpiredError wraps an error caused by an expired token.
+// It matches ErrAuthExpired and the wrapped error.
+type authExpiredError struct {
+	err error
+}
+
+func (e *authExpiredError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrAuthExpired, e.err)
+}
+
+func (e *authExpiredError) Is(target error) bool {
+	return target == ErrAuthExpired
+}
+
+func (e *authExpiredError) Unwrap() error {
+	return e.err
+}
+
+// A TruncatedResponseError is returned if a response body ended before the
+// JSON it contains was complete, usually because the server closed the
+// connection.
+// Unlike other decoding errors, this is transient, so GET requests are
+// retried if WithRetry is used.
+// It matches ErrTruncatedResponse, use errors.As to access it.
+type TruncatedResponseError struct {
+	// Received is the number of bytes of the body that were received.
+	Received int64
+}
+
+func (e *TruncatedResponseError) Error() string {
+	return fmt.Sprintf("%s after %d bytes", ErrTruncatedResponse, e.Received)
+}
+
+// Is reports whether target is ErrTruncatedResponse.
+func (e *TruncatedResponseError) Is(target error) bool {
+	return target == ErrTruncatedResponse
+}
+
+// A ChallengeError is returned when logging in if the server responded with
+// a challenge, e.g. a CAPTCHA, usually after too many login attempts.
+// It matches ErrChallengeRequired, use errors.As to access it.
+//
+// A response is considered a challenge if a field containing "captcha" or
+// "challenge" in its name is set, or present together with a challenge URL or
+// token.
+type ChallengeError struct {
+	StatusCode int
+	// URL and Token are taken from non-empty fields containing "url" or
+	// "token" in their names, other than the login tokens, if present.
+	URL   string
+	Token string
+	// Fields holds all fields of the response, with the values of sensitive
+	// fields like passwords and tokens redacted.
+	Fields map[string]json.RawMessage
+}
+
+func (e *ChallengeError) Error() string {
+	if e.URL != "" {
+		return fmt.Sprintf("%s (status %d): %s", ErrChallengeRequired, e.StatusCode, e.URL)
+	}
+	return fmt.Sprintf("%s (status %d)", ErrChallengeRequired, e.StatusCode)
+}
+
+// Is reports whether target is ErrChallengeRequired.
+func (e *ChallengeError) Is(target error) bool {
+	return target == ErrChallengeRequired
+}
+
+// parseChallenge returns a ChallengeError if body is a challenge.
+// A body is a challenge if a field containing "captcha" or "challenge" in its
+// name is set to a truthy value, or if such a field is present together with
+// a challenge URL or token.
+func parseChallenge(statusCode int, body []byte) (*ChallengeError, bool) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return nil, false
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	e := ChallengeError{StatusCode: statusCode, Fields: make(map[string]json.RawMessage, len(fields))}
+	hasChallengeField, isChallenge := false, false
+	for _, key := range keys {
+		value := fields[key]
+		if isSensitive(key) {
+			e.Fields[key] = json.RawMessage(strconv.Quote(redacted))
+		} else {
+			e.Fields[key] = json.RawMessage(redactJSON(value))
+		}
+
+		key = strings.ToLower(key)
+		if strings.Contains(key, "captcha") || strings.Contains(key, "challenge") {
+			hasChallengeField = true
+			if isTruthy(value) {
+				isChallenge = true
+			}
+		}
+
+		var s string
+		if json.Unmarshal(value, &s) != nil || s == "" {
+			continue
+		}
+		switch {
+		case strings.Contains(key, "url") && e.URL == "":
+			e.URL = s
+		case strings.Contains(key, "token") && key != "usertoken" && key != "hubtoken" && e.Token == "":
+			e.Token = s
+		}
+	}
+	if !isChallenge && !(hasChallengeField && (e.URL != "" || e.Token != "")) {
+		return nil, false
+	}
+
+	return &e, true
+}
+
+// isTruthy reports whether the JSON value b is set, i.e. not null, false,
+// zero, or an empty string, array or object.
+func isTruthy(b json.RawMessage) bool {
+	var v interface{}
+	if json.Unmarshal(b, &v) != nil {
+		return false
+	}
+
+	switch t := v.(type) {
+	case bool:
+		return t
+	case float64:
+		return t != 0
+	case string:
+		return t != ""
+	case []interface{}:
+		return len(t) > 0
+	case map[string]interface{}:
+		return len(t) > 0
+	default:
+		return false
+	}
+}
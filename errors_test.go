@@ -0,0 +1,98 @@
+package winminer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		isChallenge bool
+		url         string
+		token       string
+	}{
+		{"captcha required", `{"captchaRequired":true}`, true, "", ""},
+		{"captcha URL", `{"captchaUrl":"https://example.com/captcha"}`, true, "https://example.com/captcha", ""},
+		{"challenge token", `{"challenge":{"type":"recaptcha"},"token":"abc"}`, true, "", "abc"},
+		{"false flag with URL", `{"captchaRequired":false,"url":"https://example.com/captcha"}`, true, "https://example.com/captcha", ""},
+		{"false flag", `{"captchaRequired":false,"userToken":"secret"}`, false, "", ""},
+		{"null challenge", `{"challenge":null,"hubToken":"h"}`, false, "", ""},
+		{"empty captcha", `{"captcha":"","userToken":"secret"}`, false, "", ""},
+		{"zero challenge", `{"challengeCount":0}`, false, "", ""},
+		{"login response", `{"userToken":"secret","hubToken":"h","hubHost":"host"}`, false, "", ""},
+		{"not an object", `"captcha"`, false, "", ""},
+	}
+
+	for _, test := range tests {
+		e, ok := parseChallenge(http.StatusTooManyRequests, []byte(test.body))
+		if ok != test.isChallenge {
+			t.Errorf("%s: expected challenge=%v, got %v", test.name, test.isChallenge, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if e.URL != test.url || e.Token != test.token {
+			t.Errorf("%s: expected URL %q and token %q, got %q and %q", test.name, test.url, test.token, e.URL, e.Token)
+		}
+		if e.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("%s: expected status %d, got %d", test.name, http.StatusTooManyRequests, e.StatusCode)
+		}
+	}
+}
+
+func TestParseChallengeRedactsFields(t *testing.T) {
+	e, ok := parseChallenge(http.StatusOK, []byte(`{"captchaRequired":true,"userToken":"secret","nested":{"password":"hunter2"},"reason":"too many attempts"}`))
+	if !ok {
+		t.Fatal("expected a challenge")
+	}
+
+	if got := string(e.Fields["userToken"]); got != `"`+redacted+`"` {
+		t.Errorf("expected userToken to be redacted, got %s", got)
+	}
+	if got := string(e.Fields["nested"]); strings.Contains(got, "hunter2") {
+		t.Errorf("expected nested password to be redacted, got %s", got)
+	}
+	if got := string(e.Fields["reason"]); got != `"too many attempts"` {
+		t.Errorf("expected reason to be kept, got %s", got)
+	}
+}
+
+func TestPostLogin(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		challenge  bool
+	}{
+		{"challenge", http.StatusTooManyRequests, `{"captchaRequired":true,"captchaUrl":"https://example.com/captcha"}`, true},
+		{"challenge with 200", http.StatusOK, `{"captchaRequired":true}`, true},
+		{"undecodable", http.StatusOK, `{"userToken":"secret","hubToken":"secret","unexpected":true}`, false},
+	}
+
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.statusCode)
+			w.Write([]byte(test.body))
+		}))
+		c := newAPIClient("", "", false, []Option{WithBaseURL(srv.URL), WithStrictJSON(true)})
+
+		_, err := c.c.postLogin("test@example.com", "password")
+		srv.Close()
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if errors.Is(err, ErrChallengeRequired) != test.challenge {
+			t.Errorf("%s: expected challenge=%v, got %v", test.name, test.challenge, err)
+		}
+		if strings.Contains(err.Error(), "secret") {
+			t.Errorf("%s: error leaks a token: %s", test.name, err)
+		}
+	}
+}
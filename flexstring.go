@@ -0,0 +1,88 @@
+package winminer
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// A FlexString is a string that can be decoded from any JSON value.
+// It is used for fields whose type has never been observed, or which the
+// server sends with different types.
+//
+// JSON strings are decoded as usual, null decodes to the empty string, and any
+// other value, i.e., numbers, booleans, objects and arrays, is kept as its
+// compact JSON text.
+// Decoding a FlexString never fails for valid JSON.
+type FlexString string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *FlexString) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+
+	if bytes.Equal(b, []byte("null")) {
+		*s = ""
+		return nil
+	}
+
+	if len(b) > 0 && b[0] == '"' {
+		var str string
+		err := json.Unmarshal(b, &str)
+		if err == nil {
+			*s = FlexString(str)
+			return nil
+		}
+	}
+
+	var buf bytes.Buffer
+	if json.Compact(&buf, b) != nil {
+		*s = FlexString(b)
+		return nil
+	}
+
+	*s = FlexString(buf.String())
+	return nil
+}
+
+// String returns the value as a string.
+func (s FlexString) String() string {
+	return string(s)
+}
+
+// A FlexFloat is a float64 that can be decoded from a JSON number or a string
+// holding a number.
+// null and the empty string decode to zero.
+type FlexFloat float64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (f *FlexFloat) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+
+	if bytes.Equal(b, []byte("null")) {
+		*f = 0
+		return nil
+	}
+
+	if len(b) > 0 && b[0] == '"' {
+		var str string
+		err := json.Unmarshal(b, &str)
+		if err != nil {
+			return errors.Wrap(err, "unable to decode string")
+		}
+		b = bytes.TrimSpace([]byte(str))
+		if len(b) == 0 {
+			*f = 0
+			return nil
+		}
+	}
+
+	v, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse number")
+	}
+
+	*f = FlexFloat(v)
+	return nil
+}
@@ -0,0 +1,154 @@
+package winminer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// A hubInvocation invokes a method on the hub.
+type hubInvocation struct {
+	Hub       string        `json:"H"`
+	Method    string        `json:"M"`
+	Arguments []interface{} `json:"A"`
+	ID        string        `json:"I"`
+}
+
+// An InvocationError is returned by Invoke if the server reported an error.
+type InvocationError struct {
+	Method  string
+	Message string
+}
+
+func (e *InvocationError) Error() string {
+	return fmt.Sprintf("invocation of %s failed: %s", e.Method, e.Message)
+}
+
+func (c *WebsocketClient) newInvocation(method string, args ...interface{}) hubInvocation {
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	return hubInvocation{
+		Hub:       c.hubName,
+		Method:    method,
+		Arguments: args,
+		ID:        strconv.FormatInt(atomic.AddInt64(&c.invocationID, 1), 10),
+	}
+}
+
+// send writes an invocation to the connection.
+func (c *WebsocketClient) send(inv hubInvocation) error {
+	select {
+	case <-c.closed:
+		return ErrWebsocketClosed
+	default:
+	}
+
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode invocation")
+	}
+
+	if c.debug {
+		c.logger.WithField("b", string(b)).Debugln("websocket write")
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.ws.WriteMessage(websocket.TextMessage, b)
+}
+
+// Invoke invokes a method on the hub and waits for its result, or until the
+// context is done.
+//
+// Results are processed by the internal read loop, independently of whether
+// anybody reads messages off the connection.
+// If the server reports an error, an *InvocationError is returned.
+func (c *WebsocketClient) Invoke(ctx context.Context, method string, args ...interface{}) (json.RawMessage, error) {
+	inv := c.newInvocation(method, args...)
+	ch := make(chan *RawMessageContainer, 1)
+
+	c.state.Lock()
+	c.pending[inv.ID] = ch
+	c.state.Unlock()
+
+	defer func() {
+		c.state.Lock()
+		delete(c.pending, inv.ID)
+		c.state.Unlock()
+	}()
+
+	err := c.send(inv)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to send invocation")
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closed:
+		return nil, ErrWebsocketClosed
+	case r := <-ch:
+		if r.Error != "" {
+			return nil, &InvocationError{Method: method, Message: r.Error}
+		}
+		return r.Result, nil
+	}
+}
+
+// stateWatcherBufferSize is the number of StateChangedMessages buffered per
+// watcher.
+// Further messages are dropped until the watcher catches up.
+const stateWatcherBufferSize = 64
+
+// watchStateChanges returns a channel on which all StateChangedMessages
+// received from now on are delivered, in addition to being delivered to
+// readers as usual.
+// The returned function must be called to stop watching.
+func (c *WebsocketClient) watchStateChanges() (<-chan StateChangedMessage, func()) {
+	ch := make(chan StateChangedMessage, stateWatcherBufferSize)
+
+	c.state.Lock()
+	c.stateWatchers[ch] = struct{}{}
+	c.state.Unlock()
+
+	return ch, func() {
+		c.state.Lock()
+		delete(c.stateWatchers, ch)
+		c.state.Unlock()
+	}
+}
+
+// notifyStateWatchers delivers all MethodStateChanged messages of r to the
+// state watchers.
+// The state lock must be held.
+func (c *WebsocketClient) notifyStateWatchers(r *RawMessageContainer) {
+	if len(c.stateWatchers) == 0 {
+		return
+	}
+
+	for _, m := range r.Messages {
+		if m.Method != MethodStateChanged {
+			continue
+		}
+
+		msg, err := ParseStateChangedMessage(m)
+		if err != nil {
+			continue
+		}
+
+		for ch := range c.stateWatchers {
+			select {
+			case ch <- *msg:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package winminer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// A Transport is a SignalR transport used for the Live API.
+// The serverSentEvents transport is not supported.
+type Transport string
+
+// Supported transports.
+const (
+	// TransportAuto uses WebSockets, falling back to long polling if the
+	// WebSockets handshake fails.
+	TransportAuto Transport = ""
+
+	// TransportWebSockets uses WebSockets only.
+	TransportWebSockets Transport = "webSockets"
+
+	// TransportLongPolling uses long polling only.
+	// This is useful on networks that block WebSockets.
+	TransportLongPolling Transport = "longPolling"
+)
+
+// A transport carries SignalR frames.
+// It is implemented by *websocket.Conn and *longPollingTransport.
+type transport interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// A longPollingTransport implements the SignalR long polling transport.
+// Every call to ReadMessage performs one poll request, which blocks until the
+// server has messages for us or the poll times out.
+type longPollingTransport struct {
+	c               *lowLevelClient
+	hubBaseURL      string
+	auth2Token      string
+	connectionToken string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock        sync.Mutex
+	connected   bool
+	messageID   string
+	groupsToken string
+	deadline    time.Time
+}
+
+func (c *lowLevelClient) connectLongPolling(auth2Token, hubBaseURL, connectionToken string, resume *resumeState) *longPollingTransport {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &longPollingTransport{
+		c:               c,
+		hubBaseURL:      hubBaseURL,
+		auth2Token:      auth2Token,
+		connectionToken: connectionToken,
+		ctx:             ctx,
+		cancel:          cancel,
+		messageID:       resume.cursor,
+		groupsToken:     resume.groupsToken,
+	}
+}
+
+func (t *longPollingTransport) params() url.Values {
+	v := url.Values{}
+	v.Set("clientProtocol", "1.5")
+	v.Set("connectionData", t.c.connectionData())
+	v.Set("connectionToken", t.connectionToken)
+	v.Set("token", t.auth2Token)
+	v.Set("transport", string(TransportLongPolling))
+	return v
+}
+
+// ReadMessage polls the server for the next frame.
+// The first call connects, which returns the SignalR init message.
+func (t *longPollingTransport) ReadMessage() (int, []byte, error) {
+	t.lock.Lock()
+	endpoint := "/signalr/poll"
+	if !t.connected {
+		endpoint = "/signalr/connect"
+	}
+	v := t.params()
+	if t.messageID != "" {
+		v.Set("messageId", t.messageID)
+	}
+	if t.groupsToken != "" {
+		v.Set("groupsToken", t.groupsToken)
+	}
+	ctx, cancel := t.ctx, context.CancelFunc(func() {})
+	if !t.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, t.deadline)
+	}
+	t.lock.Unlock()
+	defer cancel()
+
+	var raw json.RawMessage
+	err := t.c.doContext(ctx, http.MethodGet, false, t.hubBaseURL+endpoint, v, nil, &raw)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "unable to poll")
+	}
+	if t.c.maxFrameSize > 0 && int64(len(raw)) > t.c.maxFrameSize {
+		return 0, nil, errors.Wrapf(ErrFrameTooLarge, "frame exceeds %d bytes", t.c.maxFrameSize)
+	}
+
+	var r RawMessageContainer
+	if json.Unmarshal(raw, &r) == nil {
+		t.lock.Lock()
+		t.connected = true
+		if r.Channel != "" {
+			t.messageID = r.Channel
+		}
+		if r.GroupsToken != "" {
+			t.groupsToken = r.GroupsToken
+		}
+		t.lock.Unlock()
+	}
+
+	return websocket.TextMessage, raw, nil
+}
+
+// WriteMessage sends data to the server.
+func (t *longPollingTransport) WriteMessage(_ int, data []byte) error {
+	form := url.Values{}
+	form.Set("data", string(data))
+
+	err := t.post(t.ctx, "/signalr/send", form)
+	if err != nil {
+		return errors.Wrap(err, "unable to send")
+	}
+
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future calls to ReadMessage.
+// A zero value means no deadline.
+func (t *longPollingTransport) SetReadDeadline(d time.Time) error {
+	t.lock.Lock()
+	t.deadline = d
+	t.lock.Unlock()
+	return nil
+}
+
+// Close aborts any in-flight requests and notifies the server that the
+// connection is closed.
+func (t *longPollingTransport) Close() error {
+	t.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return t.post(ctx, "/signalr/abort", url.Values{})
+}
+
+func (t *longPollingTransport) post(ctx context.Context, endpoint string, form url.Values) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.hubBaseURL+endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "unable to construct request")
+	}
+	req.URL.RawQuery = t.params().Encode()
+	t.c.applyHeaders(req.Header)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+
+	if t.c.debug {
+		t.c.logger.WithFields(log.Fields{"url": req.URL.Path, "form": form}).Debugln("performing long polling request")
+	}
+
+	resp, err := t.c.c.Do(req)
+	if err != nil {
+		return errors.Wrap(redactURLError(err), "unable to perform request")
+	}
+	defer resp.Body.Close()
+
+	var body io.Reader = resp.Body
+	if t.c.responseSizeLimit > 0 {
+		body = &limitedReader{r: resp.Body, remaining: t.c.responseSizeLimit}
+	}
+
+	b, err := ioutil.ReadAll(body)
+	if errors.Is(err, ErrResponseTooLarge) {
+		return err
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read response body")
+	}
+
+	if resp.StatusCode != 200 {
+		return &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(b)}
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package winminer
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLongPollingPostResponseSizeLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("a"), 1<<20))
+	}))
+	defer srv.Close()
+
+	c := newAPIClient("", "", false, []Option{WithResponseSizeLimit(1 << 10)})
+	lp := c.c.connectLongPolling("token", srv.URL, "connection", &resumeState{})
+
+	err := lp.WriteMessage(0, []byte(`{}`))
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("expected send to fail with ErrResponseTooLarge, got %v", err)
+	}
+	err = lp.Close()
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("expected abort to fail with ErrResponseTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package winminer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A MachinesDiff describes the differences between two MachinesResponses.
+type MachinesDiff struct {
+	Added   []MachineEntry
+	Removed []MachineEntry
+	Changed []DeviceChange
+}
+
+// A DeviceChange describes a change to a device.
+// Old is nil if the device was added, New is nil if it was removed.
+type DeviceChange struct {
+	MachineSID string
+	DeviceID   string
+	Old        *DeviceEntry
+	New        *DeviceEntry
+}
+
+func (c DeviceChange) String() string {
+	switch {
+	case c.Old == nil:
+		return fmt.Sprintf("%s/%s added (status %d)", c.MachineSID, c.DeviceID, c.New.Status.Status)
+	case c.New == nil:
+		return fmt.Sprintf("%s/%s removed", c.MachineSID, c.DeviceID)
+	default:
+		return fmt.Sprintf("%s/%s status %d -> %d, enabled %t -> %t", c.MachineSID, c.DeviceID, c.Old.Status.Status, c.New.Status.Status, c.Old.Enabled, c.New.Enabled)
+	}
+}
+
+// IsEmpty returns whether there are no differences.
+func (d MachinesDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d MachinesDiff) String() string {
+	if d.IsEmpty() {
+		return "no changes"
+	}
+
+	var parts []string
+	for _, m := range d.Added {
+		parts = append(parts, fmt.Sprintf("machine %s (%s) added", m.SID, m.MachineName))
+	}
+	for _, m := range d.Removed {
+		parts = append(parts, fmt.Sprintf("machine %s (%s) removed", m.SID, m.MachineName))
+	}
+	for _, c := range d.Changed {
+		parts = append(parts, c.String())
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// Diff compares r, the older response, to newer, using the machine SIDs and
+// device IDs as keys.
+// A device counts as changed if its status or enabled flag changed, or if it
+// was added to or removed from a machine that is present in both responses.
+func (r MachinesResponse) Diff(newer MachinesResponse) MachinesDiff {
+	var d MachinesDiff
+
+	oldMachines := make(map[string]MachineEntry, len(r))
+	for _, m := range r {
+		oldMachines[m.SID] = m
+	}
+	newMachines := make(map[string]MachineEntry, len(newer))
+	for _, m := range newer {
+		newMachines[m.SID] = m
+	}
+
+	for _, m := range r {
+		if _, ok := newMachines[m.SID]; !ok {
+			d.Removed = append(d.Removed, m)
+		}
+	}
+
+	for _, m := range newer {
+		old, ok := oldMachines[m.SID]
+		if !ok {
+			d.Added = append(d.Added, m)
+			continue
+		}
+		d.Changed = append(d.Changed, diffDevices(m.SID, old.Devices, m.Devices)...)
+	}
+
+	return d
+}
+
+func diffDevices(machineSID string, older, newer []DeviceEntry) []DeviceChange {
+	var changes []DeviceChange
+
+	newDevices := make(map[string]*DeviceEntry, len(newer))
+	for i := range newer {
+		newDevices[newer[i].ID] = &newer[i]
+	}
+	oldDevices := make(map[string]*DeviceEntry, len(older))
+	for i := range older {
+		oldDevices[older[i].ID] = &older[i]
+	}
+
+	for i := range older {
+		o := &older[i]
+		n, ok := newDevices[o.ID]
+		if !ok {
+			changes = append(changes, DeviceChange{MachineSID: machineSID, DeviceID: o.ID, Old: o})
+			continue
+		}
+		if o.Status.Status != n.Status.Status || o.Enabled != n.Enabled {
+			changes = append(changes, DeviceChange{MachineSID: machineSID, DeviceID: o.ID, Old: o, New: n})
+		}
+	}
+
+	for i := range newer {
+		n := &newer[i]
+		if _, ok := oldDevices[n.ID]; !ok {
+			changes = append(changes, DeviceChange{MachineSID: machineSID, DeviceID: n.ID, New: n})
+		}
+	}
+
+	return changes
+}
+
+// Sorted returns a copy of the response with machines sorted by name, then
+// SID, and the devices of each machine sorted by name, then ID.
+// The response itself is not modified.
+func (r MachinesResponse) Sorted() MachinesResponse {
+	sorted := make(MachinesResponse, len(r))
+	for i, m := range r {
+		devices := make([]DeviceEntry, len(m.Devices))
+		copy(devices, m.Devices)
+		sort.SliceStable(devices, func(i, j int) bool {
+			if devices[i].Name != devices[j].Name {
+				return devices[i].Name < devices[j].Name
+			}
+			return devices[i].ID < devices[j].ID
+		})
+
+		m.Devices = devices
+		sorted[i] = m
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].MachineName != sorted[j].MachineName {
+			return sorted[i].MachineName < sorted[j].MachineName
+		}
+		return sorted[i].SID < sorted[j].SID
+	})
+
+	return sorted
+}
+
+// Currencies returns the currencies the devices are mining, deduplicated and
+// sorted.
+func (r MachinesResponse) Currencies() []string {
+	var currencies []string
+	for _, m := range r {
+		for _, d := range m.Devices {
+			currencies = append(currencies, d.Status.Currency)
+		}
+	}
+
+	return uniqueSorted(currencies)
+}
+
+// Fingerprint returns a hash over the name, SID and client version of the
+// machine, and the IDs, statuses and enabled flags of its devices, in order
+// of their IDs.
+// Two entries have the same fingerprint if Diff would not report any changes
+// between them, and their name and client version are the same.
+func (m MachineEntry) Fingerprint() string {
+	devices := append([]DeviceEntry(nil), m.Devices...)
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].ID < devices[j].ID
+	})
+
+	// Strings are length-prefixed, so that no two entries hash the same
+	// input.
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s%d:%s%d:%s%d;", len(m.MachineName), m.MachineName, len(m.SID), m.SID, len(m.ClientVersion), m.ClientVersion, len(devices))
+	for _, d := range devices {
+		fmt.Fprintf(h, "%d:%s%d,%t;", len(d.ID), d.ID, d.Status.Status, d.Enabled)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fingerprints returns the fingerprints of the machines, keyed by SID.
+func (r MachinesResponse) fingerprints() map[string]string {
+	fps := make(map[string]string, len(r))
+	for _, m := range r {
+		fps[m.SID] = m.Fingerprint()
+	}
+
+	return fps
+}
+
+// sameFingerprints returns whether r and other hold the same machines, with
+// the same fingerprints, in any order.
+func (r MachinesResponse) sameFingerprints(other MachinesResponse) bool {
+	fps, otherFps := r.fingerprints(), other.fingerprints()
+	if len(r) != len(other) || len(fps) != len(otherFps) {
+		return false
+	}
+
+	for sid, fp := range otherFps {
+		if fps[sid] != fp {
+			return false
+		}
+	}
+
+	return true
+}
+
+// machine returns the machine with the given SID, or ErrMachineNotFound.
+func (r MachinesResponse) machine(sid string) (*MachineEntry, error) {
+	for i := range r {
+		if r[i].SID == sid {
+			return &r[i], nil
+		}
+	}
+
+	return nil, ErrMachineNotFound
+}
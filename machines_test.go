@@ -0,0 +1,146 @@
+package winminer
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	base := MachineEntry{
+		MachineName:   "rig",
+		SID:           "sid",
+		ClientVersion: "1.0",
+		Devices: []DeviceEntry{
+			{ID: "0", Enabled: true, Status: DeviceStatus{Status: StatusMining}},
+			{ID: "1", Status: DeviceStatus{Status: StatusStopping}},
+		},
+	}
+	fp := base.Fingerprint()
+	if len(fp) != 64 {
+		t.Fatalf("expected a hex SHA-256, got %q", fp)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(m *MachineEntry)
+		same   bool
+	}{
+		{"unchanged", func(m *MachineEntry) {}, true},
+		{"device order", func(m *MachineEntry) { m.Devices[0], m.Devices[1] = m.Devices[1], m.Devices[0] }, true},
+		{"other device fields", func(m *MachineEntry) {
+			m.Devices[0].Name = "GPU"
+			m.Devices[0].Status.Currency = "ETH"
+		}, true},
+		{"name", func(m *MachineEntry) { m.MachineName = "other" }, false},
+		{"SID", func(m *MachineEntry) { m.SID = "other" }, false},
+		{"client version", func(m *MachineEntry) { m.ClientVersion = "1.1" }, false},
+		{"device ID", func(m *MachineEntry) { m.Devices[1].ID = "2" }, false},
+		{"device status", func(m *MachineEntry) { m.Devices[1].Status.Status = StatusMining }, false},
+		{"device enabled", func(m *MachineEntry) { m.Devices[1].Enabled = true }, false},
+		{"device removed", func(m *MachineEntry) { m.Devices = m.Devices[:1] }, false},
+		{"fields shifted", func(m *MachineEntry) { m.MachineName, m.SID = "rigs", "id" }, false},
+	}
+
+	for _, test := range tests {
+		m := base
+		m.Devices = append([]DeviceEntry(nil), base.Devices...)
+		test.modify(&m)
+
+		if got := m.Fingerprint(); (got == fp) != test.same {
+			t.Errorf("%s: expected same fingerprint %v, got %s and %s", test.name, test.same, fp, got)
+		}
+	}
+}
+
+func TestSetSystemInfoReportsChanges(t *testing.T) {
+	machines := func(status int) []MachineEntry {
+		return []MachineEntry{
+			{SID: "a", Devices: []DeviceEntry{{ID: "0", Status: DeviceStatus{Status: status}}}},
+			{SID: "b"},
+		}
+	}
+
+	s := NewLiveState()
+	if s.SetSystemInfo(nil) {
+		t.Errorf("expected no change from empty to empty")
+	}
+	if !s.SetSystemInfo(machines(StatusMining)) {
+		t.Errorf("expected a change when adding machines")
+	}
+	if s.SetSystemInfo(machines(StatusMining)) {
+		t.Errorf("expected no change for the same machines")
+	}
+	reordered := machines(StatusMining)
+	reordered[0], reordered[1] = reordered[1], reordered[0]
+	if s.SetSystemInfo(reordered) {
+		t.Errorf("expected no change for reordered machines")
+	}
+	if !s.SetSystemInfo(machines(StatusStopping)) {
+		t.Errorf("expected a change when a status changed")
+	}
+	if !s.SetSystemInfo(machines(StatusStopping)[:1]) {
+		t.Errorf("expected a change when a machine was removed")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	older := MachinesResponse{
+		{MachineName: "A", SID: "a", Devices: []DeviceEntry{
+			{ID: "0", Enabled: true, Status: DeviceStatus{Status: StatusStopping}},
+			{ID: "1", Enabled: true, Status: DeviceStatus{Status: StatusMining}},
+		}},
+		{MachineName: "B", SID: "b"},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r MachinesResponse) MachinesResponse
+		want   string
+	}{
+		{"unchanged", func(r MachinesResponse) MachinesResponse { return r }, "no changes"},
+		{"reordered", func(r MachinesResponse) MachinesResponse {
+			r[0], r[1] = r[1], r[0]
+			r[1].Devices[0], r[1].Devices[1] = r[1].Devices[1], r[1].Devices[0]
+			return r
+		}, "no changes"},
+		{"other fields", func(r MachinesResponse) MachinesResponse {
+			r[0].MachineName = "renamed"
+			r[0].Devices[1].Status.Currency = "ETH"
+			return r
+		}, "no changes"},
+		{"machine added", func(r MachinesResponse) MachinesResponse {
+			return append(r, MachineEntry{MachineName: "C", SID: "c"})
+		}, "machine c (C) added"},
+		{"machine removed", func(r MachinesResponse) MachinesResponse { return r[:1] }, "machine b (B) removed"},
+		{"status", func(r MachinesResponse) MachinesResponse {
+			r[0].Devices[0].Status.Status = StatusMining
+			return r
+		}, "a/0 status 0 -> 8, enabled true -> true"},
+		{"enabled", func(r MachinesResponse) MachinesResponse {
+			r[0].Devices[1].Enabled = false
+			return r
+		}, "a/1 status 8 -> 8, enabled true -> false"},
+		{"devices added and removed", func(r MachinesResponse) MachinesResponse {
+			r[0].Devices[1] = DeviceEntry{ID: "2", Status: DeviceStatus{Status: StatusMining}}
+			return r
+		}, "a/1 removed; a/2 added (status 8)"},
+		{"several", func(r MachinesResponse) MachinesResponse {
+			r[0].Devices[0].Status.Status = StatusMining
+			return append(r[:1], MachineEntry{MachineName: "C", SID: "c"})
+		}, "machine c (C) added; machine b (B) removed; a/0 status 0 -> 8, enabled true -> true"},
+	}
+
+	for _, test := range tests {
+		newer := make(MachinesResponse, len(older))
+		for i, m := range older {
+			m.Devices = append([]DeviceEntry(nil), m.Devices...)
+			newer[i] = m
+		}
+		newer = test.modify(newer)
+
+		d := older.Diff(newer)
+		if got := d.String(); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+		if d.IsEmpty() != (test.want == "no changes") {
+			t.Errorf("%s: IsEmpty is %v for %s", test.name, d.IsEmpty(), d)
+		}
+	}
+}
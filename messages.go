@@ -1,11 +1,12 @@
 package winminer
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 )
 
 // Websocket method constants.
@@ -36,6 +37,22 @@ func parseString(m json.RawMessage) (string, error) {
 	return s, nil
 }
 
+// parseID parses an identifier, like a machine SID or device ID, which may be
+// sent as a string or a number.
+func parseID(m json.RawMessage) (string, error) {
+	if b := bytes.TrimSpace(m); len(b) > 0 && (b[0] == '{' || b[0] == '[') {
+		return "", errors.New("unable to unmarshal as ID")
+	}
+
+	var id FlexString
+	err := json.Unmarshal(m, &id)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to unmarshal as ID")
+	}
+
+	return string(id), nil
+}
+
 func parseBool(m json.RawMessage) (bool, error) {
 	bb, err := m.MarshalJSON()
 	if err != nil {
@@ -57,17 +74,26 @@ func checkMethodAndArgCount(msg RawMessage, method string, argCount int) error {
 	}
 
 	if len(msg.Arguments) != argCount {
-		log.WithField("args", msg.Arguments).Errorf("%s message didn't have %d args", method, argCount)
 		return fmt.Errorf("expected %d arguments, got %d", argCount, len(msg.Arguments))
 	}
 
+	for i, arg := range msg.Arguments {
+		if len(bytes.TrimSpace(arg)) == 0 {
+			return fmt.Errorf("argument %d is empty", i)
+		}
+	}
+
 	return nil
 }
 
+// A ClientConnectedMessage holds the arguments of a MethodClientConnected
+// call.
 type ClientConnectedMessage struct {
 	ClientID string
 }
 
+// ParseClientConnectedMessage parses a given RawMessage as a
+// ClientConnectedMessage.
 func ParseClientConnectedMessage(message RawMessage) (*ClientConnectedMessage, error) {
 	err := checkMethodAndArgCount(message, MethodClientConnected, 1)
 	if err != nil {
@@ -95,7 +121,7 @@ func ParseAppClosedMessage(message RawMessage) (*AppClosedMessage, error) {
 		return nil, errors.Wrap(err, "invalid message")
 	}
 
-	machineSID, err := parseString(message.Arguments[0])
+	machineSID, err := parseID(message.Arguments[0])
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to decode")
 	}
@@ -122,12 +148,12 @@ func ParseStateChangedMessage(message RawMessage) (*StateChangedMessage, error)
 		return nil, errors.Wrap(err, "invalid message")
 	}
 
-	machineSID, err := parseString(message.Arguments[0])
+	machineSID, err := parseID(message.Arguments[0])
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to decode")
 	}
 
-	deviceID, err := parseString(message.Arguments[1])
+	deviceID, err := parseID(message.Arguments[1])
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to decode")
 	}
@@ -158,49 +184,316 @@ func ParseStatusChangedMessage(message RawMessage) (*StatusChangedMessage, error
 		return nil, errors.Wrap(err, "invalid message")
 	}
 
-	machineSID, err := parseString(message.Arguments[0])
+	machineSID, err := parseID(message.Arguments[0])
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to decode")
 	}
 
-	deviceID, err := parseString(message.Arguments[1])
+	deviceID, err := parseID(message.Arguments[1])
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to decode")
 	}
 
-	bb3, err := message.Arguments[2].MarshalJSON()
+	s, err := parseDeviceStatus(message.Arguments[2])
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to marshal JSON")
+		return nil, errors.Wrap(err, "unable to decode")
 	}
 
+	return &StatusChangedMessage{MachineSID: machineSID, DeviceID: deviceID, Status: *s}, nil
+}
+
+// parseDeviceStatus parses a device status, which may be sent as an object
+// or wrapped in an array, in which case the first element is used.
+func parseDeviceStatus(b json.RawMessage) (*DeviceStatus, error) {
 	var s DeviceStatus
-	err = json.Unmarshal(bb3, &s)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to decode")
+	err := json.Unmarshal(b, &s)
+	if err == nil {
+		return &s, nil
+	}
+
+	var ss []DeviceStatus
+	if json.Unmarshal(b, &ss) == nil {
+		if len(ss) == 0 {
+			return nil, errors.New("empty device status array")
+		}
+		return &ss[0], nil
 	}
 
-	return &StatusChangedMessage{MachineSID: machineSID, DeviceID: deviceID, Status: s}, nil
+	return nil, errors.Wrapf(err, "expected a device status object or array, got %s", string(b))
 }
 
 // ParseSystemInfoMessage parses a SystemInfo message.
+// The machine information may be sent as a single machine or as a list of
+// machines, both are handled.
 func ParseSystemInfoMessage(message RawMessage) ([]MachineEntry, error) {
-	if message.Method != MethodSetSystemInfo {
-		return nil, errors.New("not a SystemInfo message")
-	}
-
-	if len(message.Arguments) != 3 {
-		log.WithField("args", message.Arguments).Errorln("SystemInfo message didn't have 3 args")
-		return nil, errors.New("expected 3 arguments")
+	err := checkMethodAndArgCount(message, MethodSetSystemInfo, 3)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid message")
 	}
 
 	// Arg 1 is Client ID
 	// Arg 2 is Machine SID
-	bb, _ := message.Arguments[2].MarshalJSON()
+	// Arg 3 is either one machine or a list of machines
+	bb := []byte(message.Arguments[2])
+	var ms []MachineEntry
+	err = json.Unmarshal(bb, &ms)
+	if err == nil {
+		return ms, nil
+	}
+
 	var m MachineEntry
-	err := json.Unmarshal(bb, &m)
+	err = json.Unmarshal(bb, &m)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to decode")
 	}
 
 	return []MachineEntry{m}, nil
 }
+
+// An Alert is a user-facing notification, as sent by MethodAddMessage and
+// MethodRemoveMessage calls.
+// The exact shape of the message has not been captured yet.
+// Messages are decoded on a best-effort basis, RawExtra always holds the raw
+// message argument.
+type Alert struct {
+	ID         string
+	Severity   string
+	Text       string
+	MachineSID string
+	Timestamp  time.Time
+	RawExtra   json.RawMessage
+}
+
+// alertPayload holds the fields we expect in an object-shaped message.
+type alertPayload struct {
+	ID        interface{} `json:"id"`
+	Severity  string      `json:"severity"`
+	Type      string      `json:"type"`
+	Text      string      `json:"text"`
+	Message   string      `json:"message"`
+	Timestamp string      `json:"timestamp"`
+	Date      string      `json:"date"`
+}
+
+func parseAlert(machineSID string, m json.RawMessage) *Alert {
+	a := Alert{MachineSID: machineSID, RawExtra: m}
+
+	s, err := parseString(m)
+	if err == nil {
+		a.ID = s
+		a.Text = s
+		return &a
+	}
+
+	var p alertPayload
+	err = json.Unmarshal(m, &p)
+	if err != nil {
+		// Neither a string nor an object, keep the raw value only.
+		a.ID = string(m)
+		return &a
+	}
+
+	a.Severity = p.Severity
+	if a.Severity == "" {
+		a.Severity = p.Type
+	}
+	a.Text = p.Text
+	if a.Text == "" {
+		a.Text = p.Message
+	}
+	if p.ID != nil {
+		a.ID = fmt.Sprint(p.ID)
+	} else {
+		a.ID = a.Text
+	}
+
+	date := p.Timestamp
+	if date == "" {
+		date = p.Date
+	}
+	if t, err := ParseDate(date); err == nil {
+		a.Timestamp = t
+	}
+
+	return &a
+}
+
+func parseAlertMessage(message RawMessage, method string) (*Alert, error) {
+	err := checkMethodAndArgCount(message, method, 2)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid message")
+	}
+
+	machineSID, err := parseID(message.Arguments[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode")
+	}
+
+	return parseAlert(machineSID, message.Arguments[1]), nil
+}
+
+// ParseAddMessage parses a given RawMessage of a MethodAddMessage call as an
+// Alert.
+func ParseAddMessage(message RawMessage) (*Alert, error) {
+	return parseAlertMessage(message, MethodAddMessage)
+}
+
+// ParseRemoveMessage parses a given RawMessage of a MethodRemoveMessage call as
+// an Alert.
+// Use LiveState.RemoveAlert to remove the alert.
+func ParseRemoveMessage(message RawMessage) (*Alert, error) {
+	return parseAlertMessage(message, MethodRemoveMessage)
+}
+
+// A SystemInfoMessage holds the machines of a MethodSetSystemInfo call.
+type SystemInfoMessage struct {
+	Machines []MachineEntry
+}
+
+// An AlertAddedMessage holds the alert of a MethodAddMessage call.
+type AlertAddedMessage struct {
+	Alert
+}
+
+// An AlertRemovedMessage holds the alert of a MethodRemoveMessage call.
+type AlertRemovedMessage struct {
+	Alert
+}
+
+// A MiningStartedMessage holds the arguments of a MethodMiningStarted call.
+type MiningStartedMessage struct {
+	ClientID string
+}
+
+// A MiningStoppedMessage holds the arguments of a MethodMiningStopped call.
+type MiningStoppedMessage struct {
+	ClientID string
+}
+
+func parseClientIDMessage(message RawMessage, method string) (string, error) {
+	err := checkMethodAndArgCount(message, method, 1)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid message")
+	}
+
+	clientID, err := parseString(message.Arguments[0])
+	if err != nil {
+		return "", errors.Wrap(err, "unable to decode")
+	}
+
+	return clientID, nil
+}
+
+// isKnownMethod returns whether messages of the given method are parsed by
+// ParseMessage, i.e., it does not return an UnknownMessage for them.
+func isKnownMethod(method string) bool {
+	switch method {
+	case MethodSetSystemInfo, MethodStatusChanged, MethodStateChanged,
+		MethodAppClosed, MethodClientConnected, MethodRemoveMessage,
+		MethodAddMessage, MethodMiningStarted, MethodMiningStopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// An UnknownMessage is returned by ParseMessage for methods that are not
+// modeled by this package.
+// Messages of unknown methods are counted per method in
+// WSStats.UnknownMethods.
+type UnknownMessage struct {
+	Method    string
+	Arguments []json.RawMessage
+}
+
+// ParseMessage parses a given RawMessage according to its method.
+// It returns one of *SystemInfoMessage, *StatusChangedMessage,
+// *StateChangedMessage, *AppClosedMessage, *ClientConnectedMessage,
+// *AlertAddedMessage, *AlertRemovedMessage, *MiningStartedMessage,
+// *MiningStoppedMessage, or *UnknownMessage for methods that are not known.
+func ParseMessage(message RawMessage) (interface{}, error) {
+	switch message.Method {
+	case MethodSetSystemInfo:
+		machines, err := ParseSystemInfoMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		return &SystemInfoMessage{Machines: machines}, nil
+	case MethodStatusChanged:
+		m, err := ParseStatusChangedMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case MethodStateChanged:
+		m, err := ParseStateChangedMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case MethodAppClosed:
+		m, err := ParseAppClosedMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case MethodClientConnected:
+		m, err := ParseClientConnectedMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	case MethodAddMessage:
+		alert, err := ParseAddMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		return &AlertAddedMessage{Alert: *alert}, nil
+	case MethodRemoveMessage:
+		alert, err := ParseRemoveMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		return &AlertRemovedMessage{Alert: *alert}, nil
+	case MethodMiningStarted:
+		clientID, err := parseClientIDMessage(message, MethodMiningStarted)
+		if err != nil {
+			return nil, err
+		}
+		return &MiningStartedMessage{ClientID: clientID}, nil
+	case MethodMiningStopped:
+		clientID, err := parseClientIDMessage(message, MethodMiningStopped)
+		if err != nil {
+			return nil, err
+		}
+		return &MiningStoppedMessage{ClientID: clientID}, nil
+	default:
+		return &UnknownMessage{Method: message.Method, Arguments: message.Arguments}, nil
+	}
+}
+
+// ParseRawMessage parses a single hub message, i.e., one element of the M
+// field of a frame, and then parses it according to its method, see
+// ParseMessage.
+// It never panics, malformed input results in an error.
+func ParseRawMessage(b []byte) (msg interface{}, err error) {
+	// The parsers handle malformed arguments themselves, see
+	// FuzzParseRawMessage, this is only a backstop.
+	defer func() {
+		if r := recover(); r != nil {
+			msg = nil
+			err = fmt.Errorf("unable to parse message: %v", r)
+		}
+	}()
+
+	var raw RawMessage
+	err = json.Unmarshal(b, &raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode")
+	}
+	if raw.Method == "" {
+		return nil, errors.New("missing method")
+	}
+
+	return ParseMessage(raw)
+}
@@ -0,0 +1,121 @@
+package winminer
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// rawMessageSeeds are hub messages, i.e., elements of the M field of a frame,
+// both well-formed and malformed.
+var rawMessageSeeds = []string{
+	`{"H":"reportinghub","M":"StatusChanged","A":["sid","0",{"status":8,"tags":["MH/s"],"hashrates":[30.5],"profits":[0.12],"currency":"ETH","extraData":""}]}`,
+	`{"H":"reportinghub","M":"StatusChanged","A":["sid",1,[{"status":2,"tags":[],"hashrates":[],"profits":[]}]]}`,
+	`{"H":"reportinghub","M":"StateChanged","A":["sid","0",false]}`,
+	`{"H":"reportinghub","M":"SetSystemInfo","A":["client","sid",{"machineName":"rig","sid":"sid","clientVersion":"1.0","devices":[{"id":"0","enabled":true,"name":"GPU 0","type":"GPU","status":{"status":8}}]}]}`,
+	`{"H":"reportinghub","M":"SetSystemInfo","A":["client","sid",[{"machineName":"rig","sid":"sid","devices":[]}]]}`,
+	`{"H":"reportinghub","M":"AppClosed","A":["sid","client"]}`,
+	`{"H":"reportinghub","M":"ClientConnected","A":["client"]}`,
+	`{"H":"reportinghub","M":"AddMessage","A":["sid",{"id":1,"severity":"warning","text":"GPU too hot","timestamp":"2020-01-01T00:00:00Z"}]}`,
+	`{"H":"reportinghub","M":"RemoveMessage","A":["sid","GPU too hot"]}`,
+	`{"H":"reportinghub","M":"MiningStarted","A":["client"]}`,
+	`{"H":"reportinghub","M":"MiningStopped","A":["client"]}`,
+	`{"H":"reportinghub","M":"Unknown","A":[1,"two",{"three":3}]}`,
+
+	// Empty, short and null arguments.
+	``,
+	`{}`,
+	`null`,
+	`[]`,
+	`{"M":"StatusChanged"}`,
+	`{"M":"StatusChanged","A":null}`,
+	`{"M":"StatusChanged","A":[]}`,
+	`{"M":"StatusChanged","A":["sid"]}`,
+	`{"M":"StatusChanged","A":[null,null,null]}`,
+	`{"M":"StateChanged","A":["sid","0",null]}`,
+	`{"M":"SetSystemInfo","A":[null,null,null]}`,
+	`{"M":"SetSystemInfo","A":["client","sid",[null]]}`,
+	`{"M":"AddMessage","A":["sid",null]}`,
+	`{"M":"ClientConnected","A":[null]}`,
+
+	// Nested and mistyped arguments.
+	`{"M":"StatusChanged","A":[["sid"],{"a":{}},[[{}]]]}`,
+	`{"M":"StatusChanged","A":["sid","0",[]]}`,
+	`{"M":"StatusChanged","A":["sid","0",{"status":"8","hashrates":"fast"}]}`,
+	`{"M":"SetSystemInfo","A":["client","sid",{"devices":{"id":1}}]}`,
+	`{"M":"AddMessage","A":["sid",[[["deep"]]]]}`,
+	`{"M":1,"A":"StatusChanged"}`,
+
+	// Whole frames, which are parsed as frames as well.
+	`{"C":"d-1F5C5A3C-B,0|Bp,2|Bq,2|Br,1","M":[{"H":"reportinghub","M":"StateChanged","A":["sid","0",true]}]}`,
+	`{"C":"s-0,0|A,0|B,0|C,0","S":1,"M":[]}`,
+	`{"C":"d-1,0|A,2|B,2|C,1","G":"token","M":[{"H":"reportinghub","M":"StatusChanged","A":null},{}]}`,
+	`{"I":"1","R":null}`,
+}
+
+func FuzzParseRawMessage(f *testing.F) {
+	for _, seed := range rawMessageSeeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ParseRawMessage(b)
+
+		// Call the parsers directly as well, so that panics are not hidden
+		// by the recover in ParseRawMessage.
+		var raw RawMessage
+		if json.Unmarshal(b, &raw) == nil {
+			ParseMessage(raw)
+		}
+		if r, err := parseFrame(b); err == nil {
+			for _, m := range r.Messages {
+				ParseMessage(m)
+			}
+		}
+	})
+}
+
+func TestParseRawMessage(t *testing.T) {
+	msg, err := ParseRawMessage([]byte(rawMessageSeeds[0]))
+	if err != nil {
+		t.Fatalf("unable to parse: %s", err)
+	}
+	m, ok := msg.(*StatusChangedMessage)
+	if !ok {
+		t.Fatalf("expected a *StatusChangedMessage, got %T", msg)
+	}
+	if m.MachineSID != "sid" || m.DeviceID != "0" || m.Status.Status != StatusMining {
+		t.Errorf("unexpected message %+v", m)
+	}
+
+	for _, seed := range []string{``, `{}`, `{"M":"StatusChanged","A":null}`, `{"M":"StatusChanged","A":["sid"]}`} {
+		_, err = ParseRawMessage([]byte(seed))
+		if err == nil {
+			t.Errorf("expected an error for %q", seed)
+		}
+	}
+}
+
+func TestIsKnownMethod(t *testing.T) {
+	known := make(map[string]struct{})
+	for _, seed := range rawMessageSeeds[:12] {
+		var raw RawMessage
+		if err := json.Unmarshal([]byte(seed), &raw); err != nil {
+			t.Fatalf("unable to decode %s: %s", seed, err)
+		}
+		msg, err := ParseMessage(raw)
+		if err != nil {
+			t.Fatalf("unable to parse %s: %s", seed, err)
+		}
+
+		_, unknown := msg.(*UnknownMessage)
+		if isKnownMethod(raw.Method) == unknown {
+			t.Errorf("isKnownMethod(%q) disagrees with ParseMessage, which returned %T", raw.Method, msg)
+		}
+		if !unknown {
+			known[raw.Method] = struct{}{}
+		}
+	}
+	if len(known) != 9 {
+		t.Errorf("expected the seeds to cover all 9 known methods, got %v", known)
+	}
+}
@@ -0,0 +1,187 @@
+package winminer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// An AccountErrors is returned by the methods of a MultiClient if the
+// operation failed for some of the accounts.
+// It maps the email of each failed account to its error.
+// The results of the other accounts are returned alongside it.
+type AccountErrors map[string]error
+
+func (e AccountErrors) Error() string {
+	return formatErrors("accounts", e)
+}
+
+// formatErrors formats errors keyed by what failed, sorted by key.
+func formatErrors(what string, errs map[string]error) string {
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	msgs := make([]string, 0, len(errs))
+	for _, key := range keys {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", key, errs[key]))
+	}
+
+	return fmt.Sprintf("%d %s failed: %s", len(errs), what, strings.Join(msgs, "; "))
+}
+
+// A MultiClient holds one APIClient per account, for operators with multiple
+// WinMiner accounts.
+type MultiClient struct {
+	// Clients maps the email of each account to its client.
+	Clients map[string]*APIClient
+}
+
+// maxConcurrentAccounts is the maximum number of accounts a MultiClient
+// performs requests for at the same time.
+const maxConcurrentAccounts = 8
+
+// forEachAccount calls fn for every email concurrently, for at most
+// maxConcurrentAccounts at a time, and collects the errors.
+func forEachAccount(emails []string, fn func(email string) error) error {
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		errs = make(AccountErrors)
+		sem  = make(chan struct{}, maxConcurrentAccounts)
+	)
+
+	for _, email := range emails {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(email string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := fn(email)
+			if err != nil {
+				lock.Lock()
+				errs[email] = err
+				lock.Unlock()
+			}
+		}(email)
+	}
+	wg.Wait()
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// forEach calls fn for every client concurrently and collects the errors.
+func (m *MultiClient) forEach(fn func(email string, c *APIClient) error) error {
+	emails := make([]string, 0, len(m.Clients))
+	for email := range m.Clients {
+		emails = append(emails, email)
+	}
+
+	return forEachAccount(emails, func(email string) error {
+		return fn(email, m.Clients[email])
+	})
+}
+
+// NewMultiClient logs in to all given accounts concurrently.
+// The options are applied to every client.
+// If some of the logins fail, the MultiClient holds the clients of the other
+// accounts and an AccountErrors is returned with it.
+// Emails must be unique, ignoring case, otherwise an error is returned before
+// logging in.
+func NewMultiClient(credentials []Credentials, debug bool, opts ...Option) (*MultiClient, error) {
+	emails := make([]string, 0, len(credentials))
+	passwords := make(map[string]string, len(credentials))
+	seen := make(map[string]struct{}, len(credentials))
+	for _, cred := range credentials {
+		key := strings.ToLower(cred.Email)
+		if _, ok := seen[key]; ok {
+			return nil, errors.Errorf("duplicate account %s", cred.Email)
+		}
+		seen[key] = struct{}{}
+
+		emails = append(emails, cred.Email)
+		passwords[cred.Email] = cred.Password
+	}
+
+	var lock sync.Mutex
+	m := &MultiClient{Clients: make(map[string]*APIClient, len(credentials))}
+
+	err := forEachAccount(emails, func(email string) error {
+		c, err := NewAPIClient(email, passwords[email], debug, opts...)
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		m.Clients[email] = c
+		lock.Unlock()
+		return nil
+	})
+
+	return m, err
+}
+
+// AggregateStats fetches the stats of all accounts concurrently and merges
+// them, i.e., concatenates the entries and sums the balances.
+// If some of the accounts fail, the merged stats of the other accounts are
+// returned with an AccountErrors.
+func (m *MultiClient) AggregateStats() (*StatsResponse, error) {
+	var lock sync.Mutex
+	var merged StatsResponse
+
+	err := m.forEach(func(_ string, c *APIClient) error {
+		stats, err := c.GetStats()
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		merged.Stats = append(merged.Stats, stats.Stats...)
+		merged.Balance = merged.Balance.Add(stats.Balance)
+		merged.Cache = merged.Cache.Add(stats.Cache)
+		return nil
+	})
+
+	return &merged, err
+}
+
+// AllMachines fetches the machines of all accounts concurrently, keyed by the
+// email of the account.
+// If some of the accounts fail, the machines of the other accounts are
+// returned with an AccountErrors.
+func (m *MultiClient) AllMachines() (map[string]MachinesResponse, error) {
+	var lock sync.Mutex
+	all := make(map[string]MachinesResponse, len(m.Clients))
+
+	err := m.forEach(func(email string, c *APIClient) error {
+		machines, err := c.GetMachines()
+		if err != nil {
+			return err
+		}
+
+		lock.Lock()
+		all[email] = *machines
+		lock.Unlock()
+		return nil
+	})
+
+	return all, err
+}
+
+// Close closes all clients.
+func (m *MultiClient) Close() error {
+	for _, c := range m.Clients {
+		c.Close()
+	}
+	return nil
+}
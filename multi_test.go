@@ -0,0 +1,66 @@
+package winminer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewMultiClientRejectsDuplicates(t *testing.T) {
+	_, err := NewMultiClient([]Credentials{
+		{Email: "a@example.com", Password: "1"},
+		{Email: "A@example.com", Password: "2"},
+	}, false, WithBaseURL("http://127.0.0.1:0"))
+	if err == nil {
+		t.Fatal("expected duplicate accounts to be rejected")
+	}
+	if _, ok := err.(AccountErrors); ok {
+		t.Errorf("expected duplicates to be rejected before logging in, got %s", err)
+	}
+}
+
+func TestForEachAccount(t *testing.T) {
+	var emails []string
+	for i := 0; i < 3*maxConcurrentAccounts; i++ {
+		emails = append(emails, fmt.Sprintf("%d@example.com", i))
+	}
+
+	var (
+		lock          sync.Mutex
+		running, peak int
+		called        = make(map[string]bool)
+	)
+	err := forEachAccount(emails, func(email string) error {
+		lock.Lock()
+		called[email] = true
+		running++
+		if running > peak {
+			peak = running
+		}
+		lock.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+
+		if email == emails[0] {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	if len(called) != len(emails) {
+		t.Errorf("expected %d calls, got %d", len(emails), len(called))
+	}
+	if peak > maxConcurrentAccounts {
+		t.Errorf("expected at most %d concurrent calls, got %d", maxConcurrentAccounts, peak)
+	}
+	errs, ok := err.(AccountErrors)
+	if !ok || len(errs) != 1 || errs[emails[0]] == nil {
+		t.Errorf("expected an AccountErrors for %s, got %v", emails[0], err)
+	}
+}
@@ -0,0 +1,314 @@
+package winminer
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// An Option configures an APIClient.
+type Option func(*APIClient)
+
+// WithBaseURL sets the base URL of the API, e.g. to use a test server, see the
+// winminertest package.
+// The default is https://api.winminer.com.
+// The URL of the Live API hub is returned by the API.
+func WithBaseURL(baseURL string) Option {
+	return func(c *APIClient) {
+		c.c.apiBaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithRetry enables retries for GET requests that fail on the connection
+// level, e.g. due to DNS failures or connection resets.
+// Requests are attempted at most maxAttempts times, waiting baseDelay before
+// the first retry and doubling the delay for every retry after that.
+// Requests that received a response from the server, including 4xx responses,
+// are not retried.
+// POST requests are never retried, to avoid duplicate logins or withdrawals.
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(c *APIClient) {
+		c.c.retryAttempts = maxAttempts
+		c.c.retryBaseDelay = baseDelay
+	}
+}
+
+// WithWebsocketCompression controls whether permessage-deflate compression is
+// offered when connecting to the Live API.
+// Compression is enabled by default.
+// If the server does not support it, the connection is not compressed.
+func WithWebsocketCompression(enabled bool) Option {
+	return func(c *APIClient) {
+		c.c.wsCompression = enabled
+	}
+}
+
+// WithStrictJSON controls whether decoding responses fails if they contain
+// fields that are not modeled by this package.
+// This is useful during development, to notice changes to the API.
+// Decoding is lenient by default.
+func WithStrictJSON(strict bool) Option {
+	return func(c *APIClient) {
+		c.c.strictJSON = strict
+	}
+}
+
+// WithStreamingDecode controls whether successful responses to GET requests
+// are decoded while they are read, instead of buffering the entire body first.
+// This reduces memory usage for large responses, e.g. for accounts with a long
+// history of stats.
+// If decoding fails, only the part of the body that has not been decoded yet
+// is included in the error.
+// Responses are always buffered in debug mode, so that they can be logged.
+// Streaming is disabled by default.
+func WithStreamingDecode(enabled bool) Option {
+	return func(c *APIClient) {
+		c.c.streamDecode = enabled
+	}
+}
+
+// WithMaintenanceDetector sets a function that is called with the body of
+// every successful response, before it is decoded.
+// If it returns true, the response is considered to be a maintenance notice
+// and ErrMaintenance is returned instead of decoding it.
+// Responses are never decoded while they are read if a detector is set, see
+// WithStreamingDecode.
+func WithMaintenanceDetector(isMaintenance func(body []byte) bool) Option {
+	return func(c *APIClient) {
+		c.c.isMaintenance = isMaintenance
+	}
+}
+
+// WithCache enables an in-memory cache for slowly changing GET endpoints,
+// i.e., GetWithdrawData and GetMachines.
+// Responses are cached for the given TTL.
+// Use RefreshWithdrawData and RefreshMachines to bypass the cache.
+func WithCache(ttl time.Duration) Option {
+	return func(c *APIClient) {
+		c.c.cache = newResponseCache(ttl)
+	}
+}
+
+// WithTransport sets the transport used for the Live API.
+// By default, WebSockets are used, falling back to long polling if the
+// WebSockets handshake fails.
+func WithTransport(t Transport) Option {
+	return func(c *APIClient) {
+		c.c.transport = t
+	}
+}
+
+// WithHTTPHeaders adds static headers to every request, including the
+// websocket handshake.
+// The Authorization and Content-Type headers set by the client take
+// precedence.
+func WithHTTPHeaders(h http.Header) Option {
+	return func(c *APIClient) {
+		c.c.headers = h.Clone()
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for all requests.
+// The client is not modified.
+// If TLS options like WithRootCAs are used as well, the client's transport
+// must be an *http.Transport or nil, its TLS configuration is replaced.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *APIClient) {
+		c.c.c = hc
+	}
+}
+
+// WithRootCAs sets the root certificate authorities used to verify the
+// server's certificate, for both HTTP requests and the websocket connection.
+// This is necessary behind TLS-intercepting proxies with a custom CA.
+// By default, the system's root CAs are used.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *APIClient) {
+		c.c.tls().RootCAs = pool
+	}
+}
+
+// WithInsecureSkipVerify disables TLS certificate verification for both HTTP
+// requests and the websocket connection.
+//
+// This is for testing only, e.g., against an httptest.NewTLSServer.
+// Never use this in production, it makes the connection vulnerable to
+// man-in-the-middle attacks.
+func WithInsecureSkipVerify() Option {
+	return func(c *APIClient) {
+		c.c.tls().InsecureSkipVerify = true
+	}
+}
+
+// WithExchangeTokens sets the tokens used to query the exchange endpoint,
+// which reports the balance shown by the miner application.
+// The tokens are not available via the API, they have to be taken from the
+// MiningToken and BalanceToken fields of the JSON body the miner application
+// POSTs to https://api.winminer.com/coin/exchange, e.g. using an intercepting
+// proxy.
+func WithExchangeTokens(miningToken, balanceToken string) Option {
+	return func(c *APIClient) {
+		c.miningToken = miningToken
+		c.balanceToken = balanceToken
+	}
+}
+
+// WithClock sets the clock used to derive the nonces of Live API requests and
+// to expire cached responses, see WithCache.
+// This is useful to make the requested URLs deterministic in tests.
+func WithClock(now func() time.Time) Option {
+	return func(c *APIClient) {
+		c.c.now = now
+	}
+}
+
+// Default intervals for keeping Live API connections alive.
+const (
+	defaultPingInterval      = 1 * time.Minute
+	defaultKeepAliveInterval = 1 * time.Minute
+)
+
+// WithPingInterval sets the interval at which the SignalR server is pinged
+// while a Live API connection is open.
+// A failed ping closes the connection.
+// The default is one minute.
+func WithPingInterval(d time.Duration) Option {
+	return func(c *APIClient) {
+		if d > 0 {
+			c.c.pingInterval = d
+		}
+	}
+}
+
+// WithKeepAliveInterval sets the interval at which KeepAlive invocations are
+// sent on an open Live API connection.
+// The invocation is skipped if a frame was received within the interval.
+// A failed KeepAlive closes the connection.
+// The default is one minute.
+func WithKeepAliveInterval(d time.Duration) Option {
+	return func(c *APIClient) {
+		if d > 0 {
+			c.c.keepAliveInterval = d
+		}
+	}
+}
+
+// WithWSDialTimeout sets the timeout for the websocket handshake when
+// connecting to the Live API.
+// The default is the timeout of websocket.DefaultDialer, i.e., 45 seconds.
+func WithWSDialTimeout(d time.Duration) Option {
+	return func(c *APIClient) {
+		c.c.wsDialTimeout = d
+	}
+}
+
+// WithTracer sets a Tracer, which is used to start a span for every HTTP
+// request and for connecting to the Live API.
+// Tracing is disabled by default.
+func WithTracer(t Tracer) Option {
+	return func(c *APIClient) {
+		c.c.tracer = t
+	}
+}
+
+// defaultResponseSizeLimit is the default maximum size of HTTP response
+// bodies.
+const defaultResponseSizeLimit = 16 << 20
+
+// WithResponseSizeLimit sets the maximum size in bytes of HTTP response
+// bodies.
+// Reading a larger body fails with an error matching ErrResponseTooLarge,
+// instead of reading it into memory.
+// A limit of zero or less removes the limit.
+// The default is 16 MiB.
+func WithResponseSizeLimit(n int64) Option {
+	return func(c *APIClient) {
+		c.c.responseSizeLimit = n
+	}
+}
+
+// WithSilenceWatchdog controls whether Live API connections on which nothing
+// was received for the disconnect timeout advertised by the server are
+// closed, e.g. because they are half-open.
+// Reads then fail with an error matching ErrServerSilent, after which the
+// connection should be re-opened, see ReconnectWebsocket.
+// The watchdog is disabled by default.
+func WithSilenceWatchdog(enabled bool) Option {
+	return func(c *APIClient) {
+		c.c.silenceWatchdog = enabled
+	}
+}
+
+// defaultMaxFrameSize is the default maximum size of websocket frames.
+const defaultMaxFrameSize = 1 << 20
+
+// WithMaxFrameSize sets the maximum size in bytes of frames read from the Live
+// API, after decompression, for both WebSockets and long polling.
+// Larger frames close the connection with an error matching ErrFrameTooLarge.
+// Via WebSockets, they are not read into memory beyond the limit, long polling
+// responses are limited by WithResponseSizeLimit instead.
+// A size of zero or less removes the limit.
+// The default is 1 MiB.
+func WithMaxFrameSize(n int64) Option {
+	return func(c *APIClient) {
+		if n < 0 {
+			n = 0
+		}
+		c.c.maxFrameSize = n
+	}
+}
+
+// WithLogLevel sets the level of the client's logger.
+// The client never logs to the global logger, so its configuration has no
+// effect on the client.
+// By default, warnings and errors are logged, or everything if debug is set
+// when constructing the client.
+// Setting log.DebugLevel or higher enables debug logging, like debug does.
+func WithLogLevel(level log.Level) Option {
+	return func(c *APIClient) {
+		c.c.logger.SetLevel(level)
+		c.c.debug = level >= log.DebugLevel
+	}
+}
+
+// Default SignalR protocol settings of the Live API.
+const (
+	defaultHubName         = "reportinghub"
+	defaultKeepAliveMethod = "KeepAlive"
+)
+
+// WithHubName sets the name of the SignalR hub to connect to, which is used
+// during the handshake and for all hub invocations.
+// The default is "reportinghub".
+func WithHubName(name string) Option {
+	return func(c *APIClient) {
+		if name != "" {
+			c.c.hubName = name
+		}
+	}
+}
+
+// WithKeepAliveMethod sets the name of the hub method invoked periodically to
+// keep Live API connections alive, see WithKeepAliveInterval.
+// The default is "KeepAlive".
+func WithKeepAliveMethod(method string) Option {
+	return func(c *APIClient) {
+		if method != "" {
+			c.c.keepAliveMethod = method
+		}
+	}
+}
+
+// WithSetStateMethod sets the name of the hub method invoked by
+// SetDeviceEnabled and SetMachineEnabled, with the machine SID, the device ID
+// and the enabled flag as arguments.
+// The method has not been captured from the website, so there is no default,
+// and both fail with ErrNoSetStateMethod unless it is set.
+func WithSetStateMethod(method string) Option {
+	return func(c *APIClient) {
+		c.c.setStateMethod = method
+	}
+}
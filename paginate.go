@@ -0,0 +1,98 @@
+package winminer
+
+import (
+	"context"
+	"io"
+)
+
+// A Paginator returns the items of an endpoint page by page.
+// None of the endpoints support paging, so the first call to Next or All
+// fetches everything, which is then returned in pages.
+// If that changes, only the Paginator needs to be adapted.
+type Paginator[T any] struct {
+	fetch    func(ctx context.Context) ([]T, error)
+	pageSize int
+
+	items   []T
+	fetched bool
+	offset  int
+}
+
+func newPaginator[T any](pageSize int, fetch func(ctx context.Context) ([]T, error)) *Paginator[T] {
+	if pageSize <= 0 {
+		// page returns everything for negative limits.
+		pageSize = -1
+	}
+	return &Paginator[T]{fetch: fetch, pageSize: pageSize}
+}
+
+func (p *Paginator[T]) ensureFetched(ctx context.Context) error {
+	if p.fetched {
+		return nil
+	}
+
+	items, err := p.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	p.items = items
+	p.fetched = true
+
+	return nil
+}
+
+// Next returns the next page of at most the page size items.
+// Returns io.EOF once all items have been returned.
+// If fetching fails, the error is returned and the next call tries again.
+func (p *Paginator[T]) Next(ctx context.Context) ([]T, error) {
+	err := p.ensureFetched(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p.offset >= len(p.items) {
+		return nil, io.EOF
+	}
+
+	items := page(p.items, p.offset, p.pageSize)
+	p.offset += len(items)
+	return items, nil
+}
+
+// All returns all items that have not been returned by Next yet.
+func (p *Paginator[T]) All(ctx context.Context) ([]T, error) {
+	err := p.ensureFetched(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := p.items[p.offset:]
+	p.offset = len(p.items)
+	return items, nil
+}
+
+// PaginateStats returns a Paginator over the entries of the stats, see
+// GetStats, with pageSize entries per page.
+// A page size of zero or less returns everything in one page.
+func (c *APIClient) PaginateStats(pageSize int) *Paginator[StatEntry] {
+	return newPaginator(pageSize, func(ctx context.Context) ([]StatEntry, error) {
+		resp, err := c.c.getStats(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Stats, nil
+	})
+}
+
+// PaginateWithdrawHistory returns a Paginator over the transactions of the
+// withdraw history, see GetWithdrawHistory, with pageSize transactions per
+// page.
+// A page size of zero or less returns everything in one page.
+func (c *APIClient) PaginateWithdrawHistory(pageSize int) *Paginator[TransactionEntry] {
+	return newPaginator(pageSize, func(ctx context.Context) ([]TransactionEntry, error) {
+		resp, err := c.c.getWithdrawHistory(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return resp.Transactions, nil
+	})
+}
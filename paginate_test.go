@@ -0,0 +1,80 @@
+package winminer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestPaginator(t *testing.T) {
+	tests := []struct {
+		name     string
+		pageSize int
+		items    []int
+		pages    [][]int
+	}{
+		{"even pages", 2, []int{1, 2, 3, 4}, [][]int{{1, 2}, {3, 4}}},
+		{"short last page", 3, []int{1, 2, 3, 4}, [][]int{{1, 2, 3}, {4}}},
+		{"one page", 10, []int{1, 2}, [][]int{{1, 2}}},
+		{"zero page size", 0, []int{1, 2, 3}, [][]int{{1, 2, 3}}},
+		{"negative page size", -1, []int{1, 2, 3}, [][]int{{1, 2, 3}}},
+		{"empty", 2, nil, nil},
+	}
+
+	for _, test := range tests {
+		fetches := 0
+		p := newPaginator(test.pageSize, func(ctx context.Context) ([]int, error) {
+			fetches++
+			return test.items, nil
+		})
+
+		var pages [][]int
+		for {
+			items, err := p.Next(context.Background())
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", test.name, err)
+			}
+			pages = append(pages, items)
+		}
+		if !reflect.DeepEqual(pages, test.pages) {
+			t.Errorf("%s: expected pages %v, got %v", test.name, test.pages, pages)
+		}
+		if fetches != 1 {
+			t.Errorf("%s: expected one fetch, got %d", test.name, fetches)
+		}
+	}
+}
+
+func TestPaginatorAllAndRetry(t *testing.T) {
+	fail := errors.New("fail")
+	calls := 0
+	p := newPaginator(2, func(ctx context.Context) ([]int, error) {
+		calls++
+		if calls == 1 {
+			return nil, fail
+		}
+		return []int{1, 2, 3, 4, 5}, nil
+	})
+
+	_, err := p.Next(context.Background())
+	if !errors.Is(err, fail) {
+		t.Fatalf("expected the fetch error, got %v", err)
+	}
+
+	items, err := p.Next(context.Background())
+	if err != nil || !reflect.DeepEqual(items, []int{1, 2}) {
+		t.Fatalf("expected the first page after retrying, got %v, %v", items, err)
+	}
+	items, err = p.All(context.Background())
+	if err != nil || !reflect.DeepEqual(items, []int{3, 4, 5}) {
+		t.Errorf("expected the remaining items, got %v, %v", items, err)
+	}
+	if _, err = p.Next(context.Background()); err != io.EOF {
+		t.Errorf("expected io.EOF after All, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package winminer
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// An AccountSnapshot holds everything the HTTP API reports about an account.
+// Parts that could not be fetched by PartialSnapshot are nil.
+type AccountSnapshot struct {
+	Stats           *StatsResponse
+	Machines        *MachinesResponse
+	WithdrawData    *WithdrawDataResponse
+	WithdrawHistory *WithdrawHistoryResponse
+}
+
+// A SnapshotErrors is returned by PartialSnapshot if some parts of the
+// snapshot could not be fetched.
+// It maps the name of each failed part, i.e., stats, machines, withdrawData
+// or withdrawHistory, to its error.
+type SnapshotErrors map[string]error
+
+func (e SnapshotErrors) Error() string {
+	return formatErrors("parts", e)
+}
+
+// Snapshot fetches the stats, machines, withdraw data and withdraw history
+// concurrently.
+// If any of them fails, the other requests are canceled and the error is
+// returned.
+// Machines and withdraw data may be returned from the cache, see WithCache.
+func (c *APIClient) Snapshot(ctx context.Context) (*AccountSnapshot, error) {
+	g, ctx := errgroup.WithContext(ctx)
+	var s AccountSnapshot
+	for _, fetch := range c.snapshotParts(ctx, &s) {
+		g.Go(fetch)
+	}
+
+	err := g.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// PartialSnapshot is like Snapshot, but fetches all parts even if some of
+// them fail.
+// The failed parts are nil, and a SnapshotErrors is returned with the
+// snapshot.
+func (c *APIClient) PartialSnapshot(ctx context.Context) (*AccountSnapshot, error) {
+	var (
+		g    errgroup.Group
+		lock sync.Mutex
+		errs = make(SnapshotErrors)
+		s    AccountSnapshot
+	)
+	for part, fetch := range c.snapshotParts(ctx, &s) {
+		part, fetch := part, fetch
+		g.Go(func() error {
+			err := fetch()
+			if err != nil {
+				lock.Lock()
+				errs[part] = err
+				lock.Unlock()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	if len(errs) != 0 {
+		return &s, errs
+	}
+	return &s, nil
+}
+
+// snapshotParts returns functions fetching each part of a snapshot into s,
+// keyed by the name of the part.
+// Each function sets only its own part, which is left nil if it fails.
+func (c *APIClient) snapshotParts(ctx context.Context, s *AccountSnapshot) map[string]func() error {
+	return map[string]func() error{
+		"stats": func() (err error) {
+			s.Stats, err = c.c.getStats(ctx)
+			return
+		},
+		"machines": func() (err error) {
+			s.Machines, err = c.c.getMachines(ctx, false)
+			return
+		},
+		"withdrawData": func() (err error) {
+			s.WithdrawData, err = c.c.getWithdrawData(ctx, false)
+			return
+		},
+		"withdrawHistory": func() (err error) {
+			s.WithdrawHistory, err = c.c.getWithdrawHistory(ctx)
+			return
+		},
+	}
+}
@@ -1,10 +1,13 @@
 package winminer
 
 import (
+	"math"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
 )
 
 // LiveState is a helper struct to keep track of Live API updates.
@@ -13,23 +16,85 @@ type LiveState struct {
 	Machines           []MachineEntry
 	DevicesLastUpdated map[string]time.Time
 	sync.Mutex
+
+	alerts []Alert
+
+	// enabled holds the enabled flag of each device from the last
+	// StateChanged message, which survives SetSystemInfo.
+	enabled map[string]bool
+
+	smoothing float64
+	smoothed  map[string]decimal.Decimal
+
+	historySize int
+	history     map[string][]statusTransition
 }
 
 // NewLiveState returns a new LiveState.
 func NewLiveState() *LiveState {
 	return &LiveState{
 		DevicesLastUpdated: make(map[string]time.Time),
+		enabled:            make(map[string]bool),
 	}
 }
 
 // SetSystemInfo clears the current state and sets it to the state received.
+// Use MergeSystemInfo to keep the state of unchanged devices instead.
 // The machine entries are kept as a reference, do not modify them later on.
-func (s *LiveState) SetSystemInfo(entries []MachineEntry) {
+// Returns whether any machine changed, by comparing their fingerprints, see
+// MachineEntry.Fingerprint, so that callers can skip work after receiving the
+// same state again, e.g. when reconnecting.
+func (s *LiveState) SetSystemInfo(entries []MachineEntry) bool {
 	s.Lock()
 	defer s.Unlock()
 
+	changed := !MachinesResponse(s.Machines).sameFingerprints(entries)
 	s.Machines = entries
 	s.DevicesLastUpdated = make(map[string]time.Time)
+	s.recordMachines(entries)
+
+	return changed
+}
+
+// MergeSystemInfo merges the state received into the current state, as an
+// alternative to SetSystemInfo, e.g. after reconnecting.
+// Machines that are present in both are updated in place, keeping their
+// order, new machines are appended and machines that are missing from entries
+// are removed.
+// DevicesLastUpdated is kept for devices whose status and enabled flag did not
+// change, and cleared for all others.
+// The machine entries are kept as a reference, do not modify them later on.
+func (s *LiveState) MergeSystemInfo(entries []MachineEntry) {
+	s.Lock()
+	defer s.Unlock()
+
+	diff := MachinesResponse(s.Machines).Diff(entries)
+	for _, m := range diff.Removed {
+		for _, d := range m.Devices {
+			delete(s.DevicesLastUpdated, d.ID)
+		}
+	}
+	for _, c := range diff.Changed {
+		delete(s.DevicesLastUpdated, c.DeviceID)
+	}
+
+	newMachines := make(map[string]MachineEntry, len(entries))
+	for _, m := range entries {
+		newMachines[m.SID] = m
+	}
+
+	machines := make([]MachineEntry, 0, len(entries))
+	for _, m := range s.Machines {
+		if n, ok := newMachines[m.SID]; ok {
+			machines = append(machines, n)
+		}
+	}
+	for _, m := range diff.Added {
+		machines = append(machines, m)
+	}
+
+	s.Machines = machines
+	s.recordMachines(entries)
 }
 
 // AddMachine adds a machine entry if it's not present already.
@@ -37,6 +102,12 @@ func (s *LiveState) SetSystemInfo(entries []MachineEntry) {
 func (s *LiveState) AddMachine(entry MachineEntry) {
 	s.Lock()
 	defer s.Unlock()
+
+	s.addMachine(entry)
+}
+
+func (s *LiveState) addMachine(entry MachineEntry) {
+	s.recordMachines([]MachineEntry{entry})
 	for i, m := range s.Machines {
 		if m.SID == entry.SID {
 			s.Machines[i] = entry
@@ -54,6 +125,27 @@ func (s *LiveState) AddMachine(entry MachineEntry) {
 func (s *LiveState) UpdateStatus(container StatusChangedMessage) error {
 	s.Lock()
 	defer s.Unlock()
+
+	return s.updateStatus(container)
+}
+
+// UpdateStatusBatch applies the given status changes in order, acquiring the
+// lock only once.
+// The returned slice holds one error per message, at the same index, which is
+// nil if the message was applied successfully.
+func (s *LiveState) UpdateStatusBatch(msgs []StatusChangedMessage) []error {
+	s.Lock()
+	defer s.Unlock()
+
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = s.updateStatus(msg)
+	}
+
+	return errs
+}
+
+func (s *LiveState) updateStatus(container StatusChangedMessage) error {
 	for i, m := range s.Machines {
 		if m.SID == container.MachineSID {
 			for j, d := range m.Devices {
@@ -63,6 +155,8 @@ func (s *LiveState) UpdateStatus(container StatusChangedMessage) error {
 					m.Devices[j] = d
 					s.Machines[i] = m
 					s.DevicesLastUpdated[container.DeviceID] = time.Now()
+					s.smooth(container.DeviceID, container.Status)
+					s.recordStatus(container.DeviceID, container.Status.Status)
 
 					return nil
 				}
@@ -70,15 +164,27 @@ func (s *LiveState) UpdateStatus(container StatusChangedMessage) error {
 			return errors.New("device not found")
 		}
 	}
-	return errors.New("machine not found")
+	return ErrMachineNotFound
 }
 
 // UpdateState updates the LiveState with the given StateChangedMessage.
 // This usually sets the enabled flag of one device to false, when mining
 // on that device is stopped.
+// The enabled flag is remembered for IsDeviceEnabled even if the device is
+// not known yet.
 func (s *LiveState) UpdateState(msg StateChangedMessage) error {
 	s.Lock()
 	defer s.Unlock()
+
+	return s.updateState(msg)
+}
+
+func (s *LiveState) updateState(msg StateChangedMessage) error {
+	if s.enabled == nil {
+		s.enabled = make(map[string]bool)
+	}
+	s.enabled[msg.DeviceID] = msg.Enabled
+
 	for i, m := range s.Machines {
 		if m.SID == msg.MachineSID {
 			for j, d := range m.Devices {
@@ -95,5 +201,361 @@ func (s *LiveState) UpdateState(msg StateChangedMessage) error {
 			return errors.New("device not found")
 		}
 	}
-	return errors.New("machine not found")
+	return ErrMachineNotFound
+}
+
+// deviceStatus returns the status of a device, or false if it is unknown.
+func (s *LiveState) deviceStatus(machineSID, deviceID string) (int, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, m := range s.Machines {
+		if m.SID != machineSID {
+			continue
+		}
+		for _, d := range m.Devices {
+			if d.ID == deviceID {
+				return d.Status.Status, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// IsDeviceEnabled returns the enabled flag of a device from the last
+// StateChanged message passed to UpdateState, and whether there was one.
+// Unlike DeviceEntry.Enabled, which is unreliable when received via the HTTP
+// API, this is not reset by SetSystemInfo or MergeSystemInfo.
+func (s *LiveState) IsDeviceEnabled(deviceID string) (bool, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	enabled, ok := s.enabled[deviceID]
+	return enabled, ok
+}
+
+// A DeviceRef identifies a device within a machine.
+type DeviceRef struct {
+	MachineSID string
+	DeviceID   string
+}
+
+// MiningDevices returns all devices that are currently mining, i.e., whose
+// status is StatusMining.
+func (s *LiveState) MiningDevices() []DeviceRef {
+	s.Lock()
+	defer s.Unlock()
+
+	var refs []DeviceRef
+	for _, m := range s.Machines {
+		for _, d := range m.Devices {
+			if d.Status.Status == StatusMining {
+				refs = append(refs, DeviceRef{MachineSID: m.SID, DeviceID: d.ID})
+			}
+		}
+	}
+
+	return refs
+}
+
+// CountByStatus returns the number of devices per status.
+func (s *LiveState) CountByStatus() map[int]int {
+	s.Lock()
+	defer s.Unlock()
+
+	counts := make(map[int]int)
+	for _, m := range s.Machines {
+		for _, d := range m.Devices {
+			counts[d.Status.Status]++
+		}
+	}
+
+	return counts
+}
+
+// isStarting returns whether the given status is one of the starting states.
+func isStarting(status int) bool {
+	switch status {
+	case StatusStarting1, StatusStarting2, StatusStarting3, StatusStarting4:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsAnyMining returns whether at least one device is mining or starting to
+// mine.
+func (s *LiveState) IsAnyMining() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, m := range s.Machines {
+		for _, d := range m.Devices {
+			if d.Status.Status == StatusMining || isStarting(d.Status.Status) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// AllStopped returns whether no device is mining or starting to mine.
+// Devices that are starting, e.g. because their machine is booting, are not
+// considered stopped.
+// This is also true if there are no devices at all.
+func (s *LiveState) AllStopped() bool {
+	return !s.IsAnyMining()
+}
+
+// AddAlert adds an alert, as received via a MethodAddMessage call.
+// An existing alert with the same machine SID and ID is replaced.
+func (s *LiveState) AddAlert(alert Alert) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.addAlert(alert)
+}
+
+func (s *LiveState) addAlert(alert Alert) {
+	for i, a := range s.alerts {
+		if a.MachineSID == alert.MachineSID && a.ID == alert.ID {
+			s.alerts[i] = alert
+			return
+		}
+	}
+
+	s.alerts = append(s.alerts, alert)
+}
+
+// RemoveAlert removes an alert, as received via a MethodRemoveMessage call.
+// Returns whether the alert was found.
+func (s *LiveState) RemoveAlert(alert Alert) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.removeAlert(alert)
+}
+
+func (s *LiveState) removeAlert(alert Alert) bool {
+	for i, a := range s.alerts {
+		if a.MachineSID == alert.MachineSID && a.ID == alert.ID {
+			s.alerts = append(s.alerts[:i], s.alerts[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// ActiveAlerts returns a copy of the currently active alerts, in the order
+// they were added.
+func (s *LiveState) ActiveAlerts() []Alert {
+	s.Lock()
+	defer s.Unlock()
+
+	alerts := make([]Alert, len(s.alerts))
+	copy(alerts, s.alerts)
+
+	return alerts
+}
+
+// SetSmoothing enables exponential smoothing of device hashrates with the
+// given smoothing factor, which must be in (0, 1].
+// Higher values discount older observations faster.
+// A factor of zero disables smoothing, which is the default.
+// Changing the factor resets the smoothed values.
+// Returns an error, and leaves smoothing unchanged, if the factor is invalid.
+func (s *LiveState) SetSmoothing(alpha float64) error {
+	if math.IsNaN(alpha) || alpha < 0 || alpha > 1 {
+		return errors.Errorf("invalid smoothing factor %v", alpha)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.smoothing = alpha
+	s.smoothed = nil
+	if alpha > 0 {
+		s.smoothed = make(map[string]decimal.Decimal)
+	}
+
+	return nil
+}
+
+// smooth updates the smoothed hashrate of a device with its total hashrate
+// from the given status.
+// The lock must be held.
+func (s *LiveState) smooth(deviceID string, status DeviceStatus) {
+	if s.smoothed == nil {
+		return
+	}
+
+	current := status.totalHashrate()
+
+	old, ok := s.smoothed[deviceID]
+	if !ok {
+		s.smoothed[deviceID] = current
+		return
+	}
+
+	alpha := decimal.NewFromFloat(s.smoothing)
+	s.smoothed[deviceID] = alpha.Mul(current).Add(decimal.New(1, 0).Sub(alpha).Mul(old))
+}
+
+// SmoothedHashrate returns the smoothed total hashrate of a device.
+// Returns false if smoothing is disabled or no status update has been
+// received for the device yet.
+func (s *LiveState) SmoothedHashrate(deviceID string) (decimal.Decimal, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	h, ok := s.smoothed[deviceID]
+	return h, ok
+}
+
+// A statusTransition records that a device changed to a status.
+type statusTransition struct {
+	at     time.Time
+	status int
+}
+
+// SetHistorySize enables recording the last n status transitions of every
+// device, which is required for Uptime.
+// Transitions are recorded from SetSystemInfo, MergeSystemInfo, AddMachine
+// and status updates, and are kept when the system info is replaced.
+// A size of zero disables recording, which is the default.
+// Changing the size resets the history.
+func (s *LiveState) SetHistorySize(n int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.historySize = n
+	s.history = nil
+	if n > 0 {
+		s.history = make(map[string][]statusTransition)
+	}
+}
+
+// recordMachines records the status of all devices of the given machines.
+// The lock must be held.
+func (s *LiveState) recordMachines(entries []MachineEntry) {
+	for _, m := range entries {
+		for _, d := range m.Devices {
+			s.recordStatus(d.ID, d.Status.Status)
+		}
+	}
+}
+
+// recordStatus records a status transition of a device, if the status
+// differs from the last one recorded.
+// The lock must be held.
+func (s *LiveState) recordStatus(deviceID string, status int) {
+	if s.history == nil {
+		return
+	}
+
+	h := s.history[deviceID]
+	if len(h) != 0 && h[len(h)-1].status == status {
+		return
+	}
+
+	h = append(h, statusTransition{at: time.Now(), status: status})
+	if len(h) > s.historySize {
+		h = append(h[:0], h[len(h)-s.historySize:]...)
+	}
+	s.history[deviceID] = h
+}
+
+// Uptime returns the fraction of time between since and now that a device
+// was mining, based on the recorded status transitions, see SetHistorySize.
+// If the history does not reach back to since, e.g. because older
+// transitions have been discarded, only the time since the oldest recorded
+// transition is considered.
+func (s *LiveState) Uptime(deviceID string, since time.Time) (float64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.history == nil {
+		return 0, errors.New("status history disabled")
+	}
+	h := s.history[deviceID]
+	if len(h) == 0 {
+		return 0, errors.New("no status history for device")
+	}
+
+	now := time.Now()
+	if h[0].at.After(since) {
+		since = h[0].at
+	}
+	if !now.After(since) {
+		return 0, errors.New("empty time range")
+	}
+
+	var mining time.Duration
+	for i, t := range h {
+		end := now
+		if i+1 < len(h) {
+			end = h[i+1].at
+		}
+		if t.status != StatusMining || !end.After(since) {
+			continue
+		}
+
+		start := t.at
+		if start.Before(since) {
+			start = since
+		}
+		mining += end.Sub(start)
+	}
+
+	return float64(mining) / float64(now.Sub(since)), nil
+}
+
+// usdRate returns the price of one unit of currency in USD.
+func usdRate(currency string, rates ExchangeRates) (decimal.Decimal, bool) {
+	switch strings.ToUpper(currency) {
+	case "USD":
+		return decimal.New(1, 0), true
+	case "BTC":
+		return rates.BTC, !rates.BTC.IsZero()
+	case "ETH":
+		return rates.ETH, !rates.ETH.IsZero()
+	case "LTC":
+		return rates.LTC, !rates.LTC.IsZero()
+	default:
+		return decimal.Zero, false
+	}
+}
+
+// TotalProfitUSD returns the sum of the profits of all devices, converted to
+// USD using the given rates, which are the USD prices of one coin, as
+// returned with the withdraw data.
+// Devices whose currency can not be converted are skipped, their number is
+// returned as well.
+func (s *LiveState) TotalProfitUSD(rates ExchangeRates) (total decimal.Decimal, skipped int) {
+	s.Lock()
+	defer s.Unlock()
+
+	total = decimal.Zero
+	for _, m := range s.Machines {
+		for _, d := range m.Devices {
+			if len(d.Status.Profits) == 0 {
+				continue
+			}
+
+			rate, ok := usdRate(d.Status.Currency, rates)
+			if !ok {
+				skipped++
+				continue
+			}
+
+			for _, p := range d.Status.Profits {
+				total = total.Add(p.Mul(rate))
+			}
+		}
+	}
+
+	return total, skipped
 }
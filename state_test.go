@@ -0,0 +1,34 @@
+package winminer_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mrd0ll4r/winminer"
+)
+
+func TestSetSmoothing(t *testing.T) {
+	tests := []struct {
+		alpha float64
+		valid bool
+	}{
+		{0, true},
+		{0.1, true},
+		{1, true},
+		{-0.1, false},
+		{1.1, false},
+		{math.NaN(), false},
+		{math.Inf(1), false},
+	}
+
+	for _, test := range tests {
+		var s winminer.LiveState
+		err := s.SetSmoothing(test.alpha)
+		if test.valid && err != nil {
+			t.Errorf("SetSmoothing(%v) failed: %s", test.alpha, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("SetSmoothing(%v) accepted an invalid factor", test.alpha)
+		}
+	}
+}
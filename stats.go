@@ -0,0 +1,258 @@
+package winminer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// dayFormat is the format used for keys in the per-day aggregations.
+const dayFormat = "2006-01-02"
+
+// IsEmpty returns whether the response contains no stats.
+// This is the case for new accounts that have not mined yet.
+// All aggregation helpers return zero values for empty responses.
+func (r *StatsResponse) IsEmpty() bool {
+	return r == nil || len(r.Stats) == 0
+}
+
+// TotalReward returns the sum of the rewards of all entries, in USD.
+func (r *StatsResponse) TotalReward() decimal.Decimal {
+	total := decimal.Zero
+	if r.IsEmpty() {
+		return total
+	}
+
+	for _, e := range r.Stats {
+		total = total.Add(e.RewardUSD)
+	}
+
+	return total
+}
+
+// ByMachine returns the sum of rewards per machine ID, in USD.
+// It returns an empty map for an empty response.
+func (r *StatsResponse) ByMachine() map[string]decimal.Decimal {
+	m := make(map[string]decimal.Decimal)
+	if r.IsEmpty() {
+		return m
+	}
+
+	for _, e := range r.Stats {
+		m[e.MachineID] = m[e.MachineID].Add(e.RewardUSD)
+	}
+
+	return m
+}
+
+// ByDay returns the sum of rewards per day, in USD.
+// The keys are formatted as YYYY-MM-DD.
+// Entries with a date that can not be parsed are keyed by their raw date.
+// It returns an empty map for an empty response.
+func (r *StatsResponse) ByDay() map[string]decimal.Decimal {
+	m := make(map[string]decimal.Decimal)
+	if r.IsEmpty() {
+		return m
+	}
+
+	for _, e := range r.Stats {
+		key := e.Date
+		t, err := ParseDate(e.Date)
+		if err == nil {
+			key = t.Format(dayFormat)
+		}
+		m[key] = m[key].Add(e.RewardUSD)
+	}
+
+	return m
+}
+
+// AverageHashSec returns the average hashrate over all entries.
+// It returns zero for an empty response.
+func (r *StatsResponse) AverageHashSec() decimal.Decimal {
+	if r.IsEmpty() {
+		return decimal.Zero
+	}
+
+	total := decimal.Zero
+	for _, e := range r.Stats {
+		total = total.Add(decimal.New(int64(e.HashSec), 0))
+	}
+
+	return total.Div(decimal.New(int64(len(r.Stats)), 0))
+}
+
+// A DateParseError is returned by helpers that need to parse the dates of
+// stat entries, if some of them could not be parsed.
+// The helpers still return results computed from the remaining entries, so
+// callers that want to skip unparseable entries can ignore this error.
+type DateParseError struct {
+	Dates []string
+}
+
+func (e *DateParseError) Error() string {
+	return fmt.Sprintf("unable to parse %d dates: %s", len(e.Dates), strings.Join(e.Dates, ", "))
+}
+
+// RewardBetween returns the sum of rewards, in USD, of entries dated within
+// [from, to).
+// If some dates can not be parsed, the sum of the remaining entries is
+// returned along with a *DateParseError.
+func (r *StatsResponse) RewardBetween(from, to time.Time) (decimal.Decimal, error) {
+	total := decimal.Zero
+	if r.IsEmpty() {
+		return total, nil
+	}
+
+	var failed []string
+	for _, e := range r.Stats {
+		t, err := ParseDate(e.Date)
+		if err != nil {
+			failed = append(failed, e.Date)
+			continue
+		}
+		if t.Before(from) || !t.Before(to) {
+			continue
+		}
+		total = total.Add(e.RewardUSD)
+	}
+
+	if len(failed) > 0 {
+		return total, &DateParseError{Dates: failed}
+	}
+	return total, nil
+}
+
+// A DailyReward is the sum of rewards, in USD, for one day.
+type DailyReward struct {
+	Day    time.Time
+	Reward decimal.Decimal
+}
+
+// DailyRewards returns the sum of rewards per day, sorted chronologically.
+// Days are midnight in the location of the entries' dates.
+// If some dates can not be parsed, the rewards of the remaining entries are
+// returned along with a *DateParseError.
+func (r *StatsResponse) DailyRewards() ([]DailyReward, error) {
+	if r.IsEmpty() {
+		return nil, nil
+	}
+
+	var failed []string
+	byDay := make(map[int64]*DailyReward)
+	for _, e := range r.Stats {
+		t, err := ParseDate(e.Date)
+		if err != nil {
+			failed = append(failed, e.Date)
+			continue
+		}
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		if _, ok := byDay[day.Unix()]; !ok {
+			byDay[day.Unix()] = &DailyReward{Day: day, Reward: decimal.Zero}
+		}
+		byDay[day.Unix()].Reward = byDay[day.Unix()].Reward.Add(e.RewardUSD)
+	}
+
+	rewards := make([]DailyReward, 0, len(byDay))
+	for _, reward := range byDay {
+		rewards = append(rewards, *reward)
+	}
+	sort.Slice(rewards, func(i, j int) bool {
+		return rewards[i].Day.Before(rewards[j].Day)
+	})
+
+	if len(failed) > 0 {
+		return rewards, &DateParseError{Dates: failed}
+	}
+	return rewards, nil
+}
+
+// RewardByCurrency returns the sum of rewards per currency, as reported in
+// the Currency field of the entries.
+// It returns an empty map for an empty response.
+func (r *StatsResponse) RewardByCurrency() map[string]decimal.Decimal {
+	m := make(map[string]decimal.Decimal)
+	if r.IsEmpty() {
+		return m
+	}
+
+	for _, e := range r.Stats {
+		m[e.Currency] = m[e.Currency].Add(e.RewardUSD)
+	}
+
+	return m
+}
+
+// IsAllUSD returns whether all entries report their currency as USD.
+// It returns true for an empty response.
+func (r *StatsResponse) IsAllUSD() bool {
+	if r.IsEmpty() {
+		return true
+	}
+
+	for _, e := range r.Stats {
+		if !strings.EqualFold(e.Currency, "USD") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Currencies returns the currencies of all entries, deduplicated and sorted.
+func (r *StatsResponse) Currencies() []string {
+	currencies := make([]string, 0, len(r.Stats))
+	for _, e := range r.Stats {
+		currencies = append(currencies, e.Currency)
+	}
+
+	return uniqueSorted(currencies)
+}
+
+// uniqueSorted returns the non-empty strings of s, deduplicated and sorted.
+func uniqueSorted(s []string) []string {
+	seen := make(map[string]struct{}, len(s))
+	unique := make([]string, 0, len(s))
+	for _, v := range s {
+		if _, ok := seen[v]; ok || v == "" {
+			continue
+		}
+		seen[v] = struct{}{}
+		unique = append(unique, v)
+	}
+
+	sort.Strings(unique)
+	return unique
+}
+
+// WriteCSV writes the entries as CSV, with a header row and one row per entry.
+// Dates are written as YYYY-MM-DD, or as-is if they can not be parsed.
+func (r *StatsResponse) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write([]string{"date", "machine_id", "currency", "reward_usd", "hash_sec"})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range r.Stats {
+		date := e.Date
+		if t, err := ParseDate(e.Date); err == nil {
+			date = t.Format(dayFormat)
+		}
+
+		err = cw.Write([]string{date, e.MachineID, e.Currency, e.RewardUSD.String(), strconv.Itoa(e.HashSec)})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
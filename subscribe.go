@@ -0,0 +1,77 @@
+package winminer
+
+// machineSIDArg returns the index of the machine SID argument for messages
+// of the given method, or -1 if messages of that method do not refer to a
+// machine.
+func machineSIDArg(method string) int {
+	switch method {
+	case MethodSetSystemInfo:
+		return 1
+	case MethodStatusChanged, MethodStateChanged, MethodAppClosed,
+		MethodAddMessage, MethodRemoveMessage:
+		return 0
+	default:
+		return -1
+	}
+}
+
+// SubscribeMachines restricts ReadNextInterestingMessages to messages about
+// the given machines.
+// Calling it again replaces the set of machines.
+//
+// Filtering is done client-side, the server still sends messages about all
+// machines of the account.
+// Messages that do not refer to a machine, like MethodClientConnected, are
+// always returned.
+// Containers that hold no messages after filtering are skipped.
+func (c *WebsocketClient) SubscribeMachines(sids ...string) {
+	subscribed := make(map[string]struct{}, len(sids))
+	for _, sid := range sids {
+		subscribed[sid] = struct{}{}
+	}
+
+	c.state.Lock()
+	c.subscribed = subscribed
+	c.state.Unlock()
+}
+
+// UnsubscribeMachines removes the restriction set by SubscribeMachines, i.e.,
+// messages about all machines are returned again.
+func (c *WebsocketClient) UnsubscribeMachines() {
+	c.state.Lock()
+	c.subscribed = nil
+	c.state.Unlock()
+}
+
+// filterMachines returns r with only the messages about subscribed machines,
+// or nil if all messages were filtered out.
+// If no subscription is set, r is returned as-is.
+func (c *WebsocketClient) filterMachines(r *RawMessageContainer) *RawMessageContainer {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	if c.subscribed == nil {
+		return r
+	}
+
+	filtered := *r
+	filtered.Messages = nil
+	for _, m := range r.Messages {
+		i := machineSIDArg(m.Method)
+		if i >= 0 && i < len(m.Arguments) {
+			sid, err := parseID(m.Arguments[i])
+			if err == nil {
+				if _, ok := c.subscribed[sid]; !ok {
+					continue
+				}
+			}
+		}
+
+		filtered.Messages = append(filtered.Messages, m)
+	}
+	if len(filtered.Messages) == 0 && len(r.Messages) != 0 {
+		return nil
+	}
+
+	return &filtered
+}
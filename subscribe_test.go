@@ -0,0 +1,61 @@
+package winminer
+
+import "testing"
+
+func TestFilterMachines(t *testing.T) {
+	container := testContainer(t,
+		`{"M":"StatusChanged","A":["a","0",{"status":8}]}`,
+		`{"M":"StatusChanged","A":["b","0",{"status":8}]}`,
+		`{"M":"SetSystemInfo","A":["client","b",{"sid":"b"}]}`,
+		`{"M":"AddMessage","A":[1,{"id":1}]}`,
+		`{"M":"ClientConnected","A":["client"]}`,
+		`{"M":"StateChanged","A":[]}`,
+	)
+
+	tests := []struct {
+		name    string
+		sids    []string // nil to unsubscribe
+		methods []string // nil if the container is dropped
+	}{
+		{"unsubscribed", nil, []string{MethodStatusChanged, MethodStatusChanged, MethodSetSystemInfo, MethodAddMessage, MethodClientConnected, MethodStateChanged}},
+		{"one machine", []string{"a"}, []string{MethodStatusChanged, MethodClientConnected, MethodStateChanged}},
+		{"numeric SID", []string{"1"}, []string{MethodAddMessage, MethodClientConnected, MethodStateChanged}},
+		{"other machines", []string{"b"}, []string{MethodStatusChanged, MethodSetSystemInfo, MethodClientConnected, MethodStateChanged}},
+		{"none", []string{}, []string{MethodClientConnected, MethodStateChanged}},
+	}
+
+	for _, test := range tests {
+		c := &WebsocketClient{}
+		if test.sids != nil {
+			c.SubscribeMachines(test.sids...)
+		} else {
+			c.UnsubscribeMachines()
+		}
+
+		var methods []string
+		if filtered := c.filterMachines(container); filtered != nil {
+			for _, m := range filtered.Messages {
+				methods = append(methods, m.Method)
+			}
+		}
+		if len(methods) != len(test.methods) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.methods, methods)
+			continue
+		}
+		for i := range methods {
+			if methods[i] != test.methods[i] {
+				t.Errorf("%s: expected %v, got %v", test.name, test.methods, methods)
+				break
+			}
+		}
+	}
+
+	c := &WebsocketClient{}
+	c.SubscribeMachines("c")
+	if filtered := c.filterMachines(testContainer(t, `{"M":"StatusChanged","A":["a","0",{"status":8}]}`)); filtered != nil {
+		t.Errorf("expected a container without subscribed messages to be dropped, got %v", filtered.Messages)
+	}
+	if filtered := c.filterMachines(&RawMessageContainer{Channel: "c"}); filtered == nil {
+		t.Errorf("expected a container without messages to be kept")
+	}
+}
@@ -0,0 +1,76 @@
+package winminer
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// A Tracer starts spans for API calls, see WithTracer.
+// This can be implemented on top of OpenTelemetry or any other tracing
+// library, without this package depending on it.
+type Tracer interface {
+	// Start starts a span with the given name as a child of the span in
+	// ctx, if any, and returns a context containing the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute of the span.
+	// Values are strings, ints, or time.Durations.
+	SetAttribute(key string, value interface{})
+	// End ends the span, recording err if it is not nil.
+	End(err error)
+}
+
+// Span attributes set by the client.
+const (
+	AttrHTTPMethod     = "http.method"
+	AttrHTTPURL        = "http.url" // without query parameters, which may contain tokens
+	AttrHTTPStatusCode = "http.status_code"
+	AttrLatency        = "latency"
+	AttrTransport      = "winminer.transport"
+	AttrConnectionID   = "winminer.connection_id"
+)
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) End(error)                        {}
+
+// startSpan starts a span using the configured tracer, if any.
+func (c *lowLevelClient) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	return c.tracer.Start(ctx, name)
+}
+
+// spanURL returns the URL to record in spans, without the query.
+func spanURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
+
+// spanName returns the name of a span for an HTTP request, i.e., the method
+// and the path.
+func spanName(method, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return method
+	}
+	return method + " " + u.Path
+}
+
+// endSpan records the latency since start and ends the span.
+func endSpan(span Span, start time.Time, err error) {
+	span.SetAttribute(AttrLatency, time.Since(start))
+	span.End(err)
+}
@@ -0,0 +1,131 @@
+package winminer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// Inconsistencies found by WithdrawHistoryResponse.Validate.
+// Use errors.Is on the returned ValidationError to check for them.
+var (
+	ErrNegativeBalance        = errors.New("negative balance")
+	ErrMissingTransactionID   = errors.New("missing transaction ID")
+	ErrDuplicateTransactionID = errors.New("duplicate transaction ID")
+	ErrInvalidAmount          = errors.New("invalid amount")
+	ErrCompletedBeforeRequest = errors.New("completed before requested")
+	ErrUnorderedDates         = errors.New("request dates not ordered")
+)
+
+// A ValidationError lists the inconsistencies found by Validate.
+// Each error wraps one of the inconsistency errors, e.g.
+// ErrDuplicateTransactionID, which errors.Is finds through the
+// ValidationError.
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d inconsistencies: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the inconsistencies.
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// parseFriendlyAmount parses an amount formatted for display, e.g. "$1,234.56",
+// by dropping everything except digits, the decimal point and the sign.
+// Returns false if the amount is empty or can not be parsed.
+func parseFriendlyAmount(s string) (decimal.Decimal, bool) {
+	cleaned := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, s)
+	if cleaned == "" {
+		return decimal.Zero, false
+	}
+
+	d, err := decimal.NewFromString(cleaned)
+	return d, err == nil
+}
+
+// Validate checks the history for internal consistency, to detect corrupted
+// or partially decoded responses.
+// It checks that
+//   - the balance is not negative,
+//   - transaction IDs are present and unique,
+//   - net amounts are neither negative nor larger than the total amounts,
+//   - completion dates are not before request dates, and
+//   - request dates are ordered, either ascending or descending.
+//
+// Amounts and dates that can not be parsed, see ParseDate, are skipped.
+// Whether the completed net amounts plus the balance are plausible is not
+// checked, as the history contains no earnings to compare them with.
+// IsCompleted is not checked against the status, as the status values are
+// not confirmed, see TransactionStatusCompleted.
+// Returns a *ValidationError listing all inconsistencies, or nil.
+func (r *WithdrawHistoryResponse) Validate() error {
+	var problems []error
+	addProblem := func(err error, format string, args ...interface{}) {
+		problems = append(problems, errors.Wrapf(err, format, args...))
+	}
+
+	if r.Balance.IsNegative() {
+		addProblem(ErrNegativeBalance, "balance %s", r.Balance)
+	}
+
+	seen := make(map[string]struct{}, len(r.Transactions))
+	ascending, descending := true, true
+	var lastRequested time.Time
+	for i, t := range r.Transactions {
+		label := t.TransactionID
+		if label == "" {
+			label = fmt.Sprintf("#%d", i)
+			addProblem(ErrMissingTransactionID, "transaction %s", label)
+		} else if _, ok := seen[label]; ok {
+			addProblem(ErrDuplicateTransactionID, "transaction %s", label)
+		}
+		seen[label] = struct{}{}
+
+		net, netOK := parseFriendlyAmount(t.FriendlyNetAmount)
+		total, totalOK := parseFriendlyAmount(t.FriendlyTotalAmount)
+		if netOK && net.IsNegative() {
+			addProblem(ErrInvalidAmount, "transaction %s has negative net amount %s", label, net)
+		}
+		if netOK && totalOK && net.GreaterThan(total) {
+			addProblem(ErrInvalidAmount, "transaction %s has net amount %s exceeding total amount %s", label, net, total)
+		}
+
+		requested, err := ParseDate(t.RequestDate)
+		if err != nil {
+			continue
+		}
+		if completed, err := ParseDate(string(t.CompletedDate)); err == nil && completed.Before(requested) {
+			addProblem(ErrCompletedBeforeRequest, "transaction %s completed at %s, requested at %s", label, t.CompletedDate, t.RequestDate)
+		}
+
+		if !lastRequested.IsZero() {
+			ascending = ascending && !requested.Before(lastRequested)
+			descending = descending && !requested.After(lastRequested)
+		}
+		lastRequested = requested
+	}
+	if !ascending && !descending {
+		problems = append(problems, ErrUnorderedDates)
+	}
+
+	if len(problems) != 0 {
+		return &ValidationError{Errors: problems}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package winminer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestValidateWithdrawHistory(t *testing.T) {
+	valid := func() TransactionEntry {
+		return TransactionEntry{
+			TransactionID:       "a",
+			IsCompleted:         true,
+			RequestDate:         "2020-01-01T00:00:00Z",
+			CompletedDate:       "2020-01-02T00:00:00Z",
+			FriendlyTotalAmount: "$10.00",
+			FriendlyNetAmount:   "$9.45",
+		}
+	}
+
+	r := WithdrawHistoryResponse{Balance: decimal.New(5, 0), Transactions: []TransactionEntry{valid()}}
+	if err := r.Validate(); err != nil {
+		t.Fatalf("expected a valid history, got %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *WithdrawHistoryResponse)
+		want   []error
+	}{
+		{"negative balance", func(r *WithdrawHistoryResponse) { r.Balance = decimal.New(-1, 0) }, []error{ErrNegativeBalance}},
+		{"missing ID", func(r *WithdrawHistoryResponse) { r.Transactions[0].TransactionID = "" }, []error{ErrMissingTransactionID}},
+		{"duplicate ID", func(r *WithdrawHistoryResponse) {
+			r.Transactions = append(r.Transactions, r.Transactions[0])
+		}, []error{ErrDuplicateTransactionID}},
+		{"negative net", func(r *WithdrawHistoryResponse) { r.Transactions[0].FriendlyNetAmount = "-$1.00" }, []error{ErrInvalidAmount}},
+		{"net exceeds total", func(r *WithdrawHistoryResponse) { r.Transactions[0].FriendlyNetAmount = "$11.00" }, []error{ErrInvalidAmount}},
+		{"completed before requested", func(r *WithdrawHistoryResponse) {
+			r.Transactions[0].CompletedDate = "2019-12-31T00:00:00Z"
+		}, []error{ErrCompletedBeforeRequest}},
+		{"unordered", func(r *WithdrawHistoryResponse) {
+			b, c := valid(), valid()
+			b.TransactionID, b.RequestDate, b.CompletedDate = "b", "2020-02-01T00:00:00Z", ""
+			c.TransactionID, c.RequestDate, c.CompletedDate = "c", "2020-01-15T00:00:00Z", ""
+			r.Transactions = append(r.Transactions, b, c)
+		}, []error{ErrUnorderedDates}},
+		{"several", func(r *WithdrawHistoryResponse) {
+			r.Balance = decimal.New(-1, 0)
+			r.Transactions[0].TransactionID = ""
+			r.Transactions[0].FriendlyNetAmount = "$11.00"
+		}, []error{ErrNegativeBalance, ErrMissingTransactionID, ErrInvalidAmount}},
+	}
+
+	for _, test := range tests {
+		r := WithdrawHistoryResponse{Balance: decimal.New(5, 0), Transactions: []TransactionEntry{valid()}}
+		test.modify(&r)
+
+		err := r.Validate()
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected a ValidationError, got %v", test.name, err)
+			continue
+		}
+		if len(validationErr.Errors) != len(test.want) {
+			t.Errorf("%s: expected %d inconsistencies, got %s", test.name, len(test.want), err)
+		}
+		for _, want := range test.want {
+			if !errors.Is(err, want) {
+				t.Errorf("%s: expected %q, got %s", test.name, want, err)
+			}
+		}
+	}
+}
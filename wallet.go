@@ -0,0 +1,247 @@
+package winminer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/sha3"
+)
+
+// An addressFormat describes the address formats of a Bitcoin-like currency.
+type addressFormat struct {
+	versions []byte // Base58Check version bytes
+	hrp      string // bech32 human-readable part
+}
+
+var addressFormats = map[string]addressFormat{
+	"BTC": {versions: []byte{0x00, 0x05}, hrp: "bc"},
+	"LTC": {versions: []byte{0x30, 0x32, 0x05}, hrp: "ltc"},
+}
+
+// ValidateWalletAddress performs basic offline validation of a wallet address
+// for the given currency, which is one of BTC, LTC or ETH.
+//
+// BTC and LTC addresses are checked as either Base58Check addresses with a
+// known version byte, or as bech32/bech32m encoded SegWit addresses.
+// ETH addresses must be 0x followed by 40 hex digits.
+// If they are mixed-case, the EIP-55 checksum is verified.
+//
+// This catches typos, it does not check whether the address exists.
+func ValidateWalletAddress(currency, address string) error {
+	currency = strings.ToUpper(currency)
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return errors.Errorf("empty %s address", currency)
+	}
+
+	if currency == "ETH" {
+		return validateETHAddress(address)
+	}
+
+	format, ok := addressFormats[currency]
+	if !ok {
+		return errors.Errorf("unsupported currency %q", currency)
+	}
+
+	if strings.HasPrefix(strings.ToLower(address), format.hrp+"1") {
+		return validateSegwitAddress(currency, format.hrp, address)
+	}
+	// Base58Check addresses are at most 35 characters long, so longer ones
+	// are bech32 addresses with a different human-readable part.
+	if i := strings.LastIndexByte(address, '1'); i > 0 && len(address) > 35 {
+		return errors.Errorf("invalid %s address prefix %q", currency, address[:i])
+	}
+
+	return validateBase58Address(currency, format.versions, address)
+}
+
+func validateETHAddress(address string) error {
+	if !strings.HasPrefix(address, "0x") && !strings.HasPrefix(address, "0X") {
+		return errors.New("invalid ETH address prefix")
+	}
+	hexPart := address[2:]
+	if len(hexPart) != 40 {
+		return errors.Errorf("invalid ETH address length %d", len(address))
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return errors.New("invalid ETH address characters")
+	}
+
+	lower := strings.ToLower(hexPart)
+	if hexPart == lower || hexPart == strings.ToUpper(hexPart) {
+		// Not checksummed.
+		return nil
+	}
+
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(lower))
+	hash := h.Sum(nil)
+
+	for i, c := range hexPart {
+		if c >= '0' && c <= '9' {
+			continue
+		}
+
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		upper := nibble&0xf >= 8
+		if upper != (c >= 'A' && c <= 'F') {
+			return errors.New("invalid ETH address checksum")
+		}
+	}
+
+	return nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// decodeBase58 decodes a base58 string.
+// It returns false if s contains characters outside the alphabet.
+func decodeBase58(s string) ([]byte, bool) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, false
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), n.Bytes()...), true
+}
+
+func validateBase58Address(currency string, versions []byte, address string) error {
+	if len(address) < 26 || len(address) > 35 {
+		return errors.Errorf("invalid %s address length %d", currency, len(address))
+	}
+
+	b, ok := decodeBase58(address)
+	if !ok {
+		return errors.Errorf("invalid %s address characters", currency)
+	}
+	if len(b) != 25 {
+		return errors.Errorf("invalid %s address length %d", currency, len(address))
+	}
+
+	payload, checksum := b[:21], b[21:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], checksum) {
+		return errors.Errorf("invalid %s address checksum", currency)
+	}
+
+	if bytes.IndexByte(versions, payload[0]) < 0 {
+		return errors.Errorf("invalid %s address prefix", currency)
+	}
+
+	return nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Checksum constants of bech32 (BIP 173) and bech32m (BIP 350).
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	values := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	return values
+}
+
+// convertBits regroups 5-bit groups to bytes, rejecting non-zero padding.
+func convertBits(data []byte) ([]byte, bool) {
+	var acc, bits uint
+	var out []byte
+	for _, v := range data {
+		acc = acc<<5 | uint(v)
+		bits += 5
+		for bits >= 8 {
+			bits -= 8
+			out = append(out, byte(acc>>bits))
+		}
+	}
+	if bits >= 5 || (acc<<(8-bits))&0xff != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+func validateSegwitAddress(currency, hrp, address string) error {
+	if len(address) > 90 {
+		return errors.Errorf("invalid %s address length %d", currency, len(address))
+	}
+	if address != strings.ToLower(address) && address != strings.ToUpper(address) {
+		return errors.Errorf("invalid %s address: mixed case", currency)
+	}
+	address = strings.ToLower(address)
+
+	data := make([]byte, 0, len(address)-len(hrp)-1)
+	for _, c := range address[len(hrp)+1:] {
+		i := strings.IndexRune(bech32Charset, c)
+		if i < 0 {
+			return errors.Errorf("invalid %s address characters", currency)
+		}
+		data = append(data, byte(i))
+	}
+	if len(data) < 7 {
+		return errors.Errorf("invalid %s address length %d", currency, len(address))
+	}
+
+	witnessVersion := data[0]
+	checksum := bech32Polymod(append(bech32HRPExpand(hrp), data...))
+	switch {
+	case witnessVersion == 0 && checksum == bech32Const:
+	case witnessVersion > 0 && checksum == bech32mConst:
+	default:
+		return errors.Errorf("invalid %s address checksum", currency)
+	}
+
+	program, ok := convertBits(data[1 : len(data)-6])
+	if !ok || witnessVersion > 16 || len(program) < 2 || len(program) > 40 {
+		return errors.Errorf("invalid %s witness program", currency)
+	}
+	if witnessVersion == 0 && len(program) != 20 && len(program) != 32 {
+		return errors.Errorf("invalid %s witness program", currency)
+	}
+
+	return nil
+}
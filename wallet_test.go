@@ -0,0 +1,64 @@
+package winminer_test
+
+import (
+	"testing"
+
+	"github.com/mrd0ll4r/winminer"
+)
+
+func TestValidateWalletAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		currency string
+		address  string
+		valid    bool
+	}{
+		{"BTC P2PKH", "BTC", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", true},
+		{"BTC P2SH", "BTC", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", true},
+		{"BTC bech32", "BTC", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", true},
+		{"BTC bech32 upper case", "BTC", "BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", true},
+		{"BTC bech32m", "BTC", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", true},
+		{"BTC lower case currency", "btc", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", true},
+		{"BTC surrounding whitespace", "BTC", " 1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa\n", true},
+		{"BTC empty", "BTC", "", false},
+		{"BTC base58 wrong checksum", "BTC", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb", false},
+		{"BTC base58 invalid characters", "BTC", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfN0", false},
+		{"BTC base58 LTC version", "BTC", "LUEweDxDA4WhvWiNXXSxjM9CYzHPJv4QQF", false},
+		{"BTC bech32 wrong checksum", "BTC", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5", false},
+		{"BTC bech32 mixed case", "BTC", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kV8F3T4", false},
+		{"BTC bech32 wrong HRP", "BTC", "tb1qvt5s0v2uhuna2sjnn84ldu8m2r4m3rcclfw5ch", false},
+		{"BTC bech32 LTC HRP", "BTC", "ltc1qvt5s0v2uhuna2sjnn84ldu8m2r4m3rcc3n0rm5", false},
+
+		{"LTC base58 L", "LTC", "LUEweDxDA4WhvWiNXXSxjM9CYzHPJv4QQF", true},
+		{"LTC base58 M", "LTC", "MGv9cSYnaRSTZNzYaN7bhbgmozoGkKBvCn", true},
+		{"LTC base58 legacy P2SH", "LTC", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", true},
+		{"LTC bech32", "LTC", "ltc1qvt5s0v2uhuna2sjnn84ldu8m2r4m3rcc3n0rm5", true},
+		{"LTC bech32m", "LTC", "ltc1pvt5s0v2uhuna2sjnn84ldu8m2r4m3rcc03gyna", true},
+		{"LTC base58 wrong checksum", "LTC", "LUEweDxDA4WhvWiNXXSxjM9CYzHPJv4QQG", false},
+		{"LTC base58 BTC version", "LTC", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", false},
+		{"LTC bech32 wrong HRP", "LTC", "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", false},
+		{"LTC bech32 mixed case", "LTC", "LTC1qvt5s0v2uhuna2sjnn84ldu8m2r4m3rcc3n0rm5", false},
+		{"LTC witness v0 with bech32m checksum", "LTC", "ltc1qvt5s0v2uhuna2sjnn84ldu8m2r4m3rccy0l07k", false},
+
+		{"ETH checksummed", "ETH", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", true},
+		{"ETH checksummed 2", "ETH", "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359", true},
+		{"ETH lower case", "ETH", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", true},
+		{"ETH upper case", "ETH", "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", true},
+		{"ETH wrong checksum", "ETH", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", false},
+		{"ETH missing prefix", "ETH", "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", false},
+		{"ETH too short", "ETH", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea", false},
+		{"ETH invalid characters", "ETH", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaeg", false},
+
+		{"unsupported currency", "DOGE", "DH5yaieqoZN36fDVciNyRueRGvGLR3mr7L", false},
+	}
+
+	for _, test := range tests {
+		err := winminer.ValidateWalletAddress(test.currency, test.address)
+		if test.valid && err != nil {
+			t.Errorf("%s: %s is valid, got %s", test.name, test.address, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: %s is invalid, got no error", test.name, test.address)
+		}
+	}
+}
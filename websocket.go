@@ -1,11 +1,14 @@
 package winminer
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"io"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -25,138 +28,780 @@ const (
 )
 
 // A WebsocketClient is a client for the Winminer Live API.
+//
+// Frames are read off the connection by an internal goroutine and buffered.
+// Keep-alive frames and invocation results are handled internally and not
+// buffered.
+// If the buffer is full because nobody reads, further frames are dropped, see
+// WSStats.DroppedFrames, but connection-level processing like Invoke keeps
+// working.
+// All methods are safe for concurrent use.
+// Concurrent calls to Read or ReadNextInterestingMessages each receive
+// different frames, i.e., every frame is delivered to exactly one caller.
+// If multiple consumers need to see all messages, use a single reader and
+// distribute the messages yourself.
 type WebsocketClient struct {
-	ws     *websocket.Conn
-	wsLock sync.Mutex
+	ws        transport
+	writeLock sync.Mutex
+
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	failOnce  sync.Once
+	closed    chan struct{}
+	done      chan struct{}
+	err       chan error
+	frames    chan frame
+	readDone  chan struct{}
+
+	tap         chan []byte
+	tapEnabled  int32
+	droppedTaps int64
+
+	connectionID    string
+	hubName         string
+	keepAliveMethod string
+	auth2           func(ctx context.Context) (*Auth2Response, error)
+
+	state       sync.RWMutex
+	initialized bool
+	groupsToken string
+	lastErr     error
+	auth2Token  string
+
+	lastCursor  string
+	seenCursors map[string]struct{}
+	cursorLog   []string
+
+	pingRTTs     []time.Duration
+	stats        WSStats
+	lastReceived time.Time
+
+	invocationID int64
+	pending      map[string]chan *RawMessageContainer
+
+	stateWatchers map[chan StateChangedMessage]struct{}
+	subscribed    map[string]struct{}
+
+	debug  bool
+	logger *log.Logger
+}
+
+// A resumeState is carried over from one Live API connection to the next, so
+// that SignalR can resume sending messages where the previous connection left
+// off, and replayed messages are skipped.
+type resumeState struct {
+	cursor      string
+	groupsToken string
+	cursorLog   []string
+}
 
-	wg     sync.WaitGroup
-	closed chan struct{}
-	err    chan error
+// resumeState returns the state to resume the connection from.
+func (c *WebsocketClient) resumeState() *resumeState {
+	c.state.RLock()
+	defer c.state.RUnlock()
 
-	debug bool
+	return &resumeState{
+		cursor:      c.lastCursor,
+		groupsToken: c.groupsToken,
+		cursorLog:   append([]string(nil), c.cursorLog...),
+	}
 }
 
-func newWebsocketClient(c *lowLevelClient) (*WebsocketClient, error) {
-	nonce := time.Now().UnixNano() / 1000000
+// newWebsocketClient connects to the Live API.
+// If resume is not nil, the connection resumes from there.
+func newWebsocketClient(c *lowLevelClient, resume *resumeState) (_ *WebsocketClient, err error) {
+	ctx, span := c.startSpan(context.Background(), "winminer.connect")
+	defer func(start time.Time) {
+		endSpan(span, start, err)
+	}(time.Now())
+
+	nonce := c.nonce()
 	client := WebsocketClient{
+		logger: c.logger,
+
+		hubName:         c.hubName,
+		keepAliveMethod: c.keepAliveMethod,
+
 		closed: make(chan struct{}),
-		err:    make(chan error),
+		done:   make(chan struct{}),
+		err:    make(chan error, 1),
+
+		frames:   make(chan frame, frameBufferSize),
+		readDone: make(chan struct{}),
+		tap:      make(chan []byte, tapBufferSize),
+
+		seenCursors: make(map[string]struct{}),
+		pending:     make(map[string]chan *RawMessageContainer),
+
+		stateWatchers: make(map[chan StateChangedMessage]struct{}),
+	}
+	if resume == nil {
+		resume = &resumeState{}
+	}
+	client.lastCursor = resume.cursor
+	client.groupsToken = resume.groupsToken
+	for _, cursor := range resume.cursorLog {
+		client.seenCursors[cursor] = struct{}{}
+		client.cursorLog = append(client.cursorLog, cursor)
 	}
 
-	auth2Resp, err := c.auth2()
+	auth2Resp, err := c.auth2(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to auth2")
 	}
 	hubBaseURL := auth2Resp.Host
 	auth2Token := auth2Resp.Token
+	client.auth2 = c.auth2
+	client.auth2Token = auth2Token
 
-	negResp, err := c.negotiate(nonce, auth2Token, hubBaseURL)
+	negResp, err := c.negotiate(ctx, nonce, auth2Token, hubBaseURL)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to negotiate")
 	}
 	connectionToken := negResp.ConnectionToken
+	client.connectionID = negResp.ConnectionID
+	span.SetAttribute(AttrConnectionID, client.connectionID)
 	nonce++
 
-	conn, err := c.connect(auth2Token, hubBaseURL, connectionToken)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to connect")
+	var conn transport
+	transportName := c.transport
+	switch transportName {
+	case TransportLongPolling:
+		conn = c.connectLongPolling(auth2Token, hubBaseURL, connectionToken, resume)
+	case TransportWebSockets:
+		conn, err = c.connect(auth2Token, hubBaseURL, connectionToken, resume)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to connect")
+		}
+	default:
+		transportName = TransportWebSockets
+		conn, err = c.connect(auth2Token, hubBaseURL, connectionToken, resume)
+		if err != nil {
+			c.logger.WithField("err", err).Warnln("unable to connect via WebSockets, falling back to long polling")
+			transportName = TransportLongPolling
+			conn = c.connectLongPolling(auth2Token, hubBaseURL, connectionToken, resume)
+		}
 	}
 	client.ws = conn
+	span.SetAttribute(AttrTransport, string(transportName))
 
-	err = c.start(nonce, auth2Token, hubBaseURL, connectionToken)
+	err = client.awaitInit(initTimeout(negResp))
 	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "unable to initialize")
+	}
+	client.lastReceived = time.Now()
+
+	client.wg.Add(1)
+	go client.readLoop()
+
+	err = c.start(ctx, nonce, transportName, auth2Token, hubBaseURL, connectionToken)
+	if err != nil {
+		client.close()
 		return nil, errors.Wrap(err, "unable to start")
 	}
+	if c.debug {
+		c.logger.WithFields(log.Fields{"connectionID": client.connectionID, "transport": transportName}).Debugln("live API connected")
+	}
+
+	var silence time.Duration
+	if c.silenceWatchdog {
+		silence = silenceTimeout(negResp)
+	}
 
 	client.wg.Add(1)
-	go func() {
-		defer client.wg.Done()
+	go client.supervise(c.pingInterval, c.keepAliveInterval, silence, func() error {
+		nonce++
+		err := c.ping(nonce, client.currentAuth2Token(), hubBaseURL)
+		if isAuthError(err) {
+			return &authExpiredError{err: err}
+		}
+		return err
+	})
 
-		t := time.NewTicker(1 * time.Minute)
+	return &client, nil
+}
 
-		for {
-			select {
-			case <-client.closed:
-				t.Stop()
+// RefreshAuth2 re-runs auth2 and uses the new token for subsequent pings of
+// the connection.
+// SignalR only checks the token of the connection itself when connecting, so
+// it can not be replaced without reconnecting.
+// If pinging fails because the token was rejected, the connection is closed
+// and reads fail with an error matching ErrAuthExpired.
+func (c *WebsocketClient) RefreshAuth2() error {
+	resp, err := c.auth2(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "unable to auth2")
+	}
+
+	c.state.Lock()
+	c.auth2Token = resp.Token
+	c.state.Unlock()
+
+	return nil
+}
+
+func (c *WebsocketClient) currentAuth2Token() string {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	return c.auth2Token
+}
+
+// ConnectionID returns the SignalR connection ID assigned by the server
+// during negotiation.
+// This identifies the session, e.g. when contacting support.
+func (c *WebsocketClient) ConnectionID() string {
+	return c.connectionID
+}
+
+var _ io.Closer = (*WebsocketClient)(nil)
+
+// Close closes the connection and waits for its goroutines to exit.
+// Closing an already closed connection is a no-op.
+// If the connection was obtained from an APIClient, the APIClient will
+// connect a new one on the next call to ConnectWebsocket.
+func (c *WebsocketClient) Close() error {
+	c.close()
+	return nil
+}
+
+// isClosed returns whether the connection has been closed.
+func (c *WebsocketClient) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes the connection and waits for its goroutines to exit.
+// Concurrent calls block until the connection has been closed.
+func (c *WebsocketClient) close() {
+	c.closeOnce.Do(func() {
+		c.logger.Debugln("websocket close() called")
+		close(c.closed)
+
+		// Closing the connection unblocks the read loop.
+		c.ws.Close()
+		c.wg.Wait()
+
+		close(c.done)
+	})
+}
+
+// fail records err as the fatal error of the connection, reports it to the
+// next call to Read and tears the connection down.
+// Only the first error is recorded, later ones are ignored.
+// It never blocks, so it is safe to call from the connection's goroutines.
+func (c *WebsocketClient) fail(err error) {
+	c.failOnce.Do(func() {
+		c.setLastErr(err)
+		c.err <- err // buffered, see newWebsocketClient
+
+		// close waits for our goroutines, which may include the caller.
+		go c.close()
+	})
+}
+
+// closedErr returns the error to report to readers of a closed connection.
+func (c *WebsocketClient) closedErr() error {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	if c.lastErr == nil {
+		return ErrWebsocketClosed
+	}
+	return errors.Wrap(c.lastErr, "connection broken")
+}
+
+// supervise periodically pings the SignalR server via the given function and
+// sends KeepAlive invocations on the connection, until the connection is
+// closed.
+// The first failure of either is fatal, see fail.
+// If silence is positive, the connection also fails with ErrServerSilent if
+// nothing was received for that long.
+func (c *WebsocketClient) supervise(pingInterval, keepAliveInterval, silence time.Duration, ping func() error) {
+	defer c.wg.Done()
+
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+	keepAliveTicker := time.NewTicker(keepAliveInterval)
+	defer keepAliveTicker.Stop()
+
+	var watchdog <-chan time.Time
+	if silence > 0 {
+		t := time.NewTicker(silence / 4)
+		defer t.Stop()
+		watchdog = t.C
+	}
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-watchdog:
+			if time.Since(c.LastReceived()) < silence {
+				continue
+			}
+
+			c.logger.WithField("silence", silence).Errorln("server went silent")
+			c.fail(errors.Wrapf(ErrServerSilent, "nothing received for %s", silence))
+			return
+		case <-pingTicker.C:
+			before := time.Now()
+			err := ping()
+			c.updateStats(func(s *WSStats) {
+				s.PingsSent++
+				if err != nil {
+					s.PingsFailed++
+				}
+			})
+			if err != nil {
+				c.logger.WithField("err", err).Errorln("unable to ping signalr")
+				c.fail(errors.Wrap(err, "unable to ping signalr"))
 				return
-			case <-t.C:
-				nonce++
-				err = c.ping(nonce, auth2Token, hubBaseURL)
+			}
+			c.recordPingRTT(time.Since(before))
+		case <-keepAliveTicker.C:
+			if time.Since(c.LastReceived()) < keepAliveInterval {
+				// The connection is evidently alive.
+				continue
+			}
+
+			err := c.send(c.newInvocation(c.keepAliveMethod))
+			c.updateStats(func(s *WSStats) {
+				s.KeepAlivesSent++
 				if err != nil {
-					log.WithField("err", err).Errorln("unable to ping signalr")
-					client.err <- errors.Wrap(err, "unable to ping signalr")
+					s.KeepAlivesFailed++
 				}
+			})
+			if err != nil {
+				c.logger.WithField("err", err).Errorln("unable to ping WSS")
+				c.fail(errors.Wrap(err, "unable to ping WSS"))
+				return
 			}
 		}
-	}()
+	}
+}
 
-	client.wg.Add(1)
-	go func() {
-		defer client.wg.Done()
-		t := time.NewTicker(1 * time.Minute)
-		currentNonce := 1
+func (c *WebsocketClient) setLastErr(err error) {
+	c.state.Lock()
+	c.lastErr = err
+	c.state.Unlock()
+}
+
+// WSStats holds counters about a Live API connection.
+type WSStats struct {
+	FramesRead        int64
+	BytesReceived     int64
+	ParseErrors       int64 // frames that could not be parsed
+	InterestingFrames int64 // frames returned by ReadNextInterestingMessages
+	DroppedFrames     int64 // frames dropped because the buffer was full
+	PingsSent         int64
+	PingsFailed       int64
+	KeepAlivesSent    int64
+	KeepAlivesFailed  int64
+	// UnknownMethods counts the messages received per hub method that is
+	// not modeled by this package, see UnknownMessage.
+	UnknownMethods map[string]int64
+}
+
+func (c *WebsocketClient) updateStats(f func(*WSStats)) {
+	c.state.Lock()
+	f(&c.stats)
+	c.state.Unlock()
+}
+
+// LastReceived returns the time the last frame was received, including
+// keep-alive frames sent by the server and the SignalR init message.
+// KeepAlive invocations are only sent if nothing has been received for the
+// keep-alive interval, see WithKeepAliveInterval.
+func (c *WebsocketClient) LastReceived() time.Time {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	return c.lastReceived
+}
+
+// Stats returns a snapshot of the connection's counters.
+func (c *WebsocketClient) Stats() WSStats {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	stats := c.stats
+	if c.stats.UnknownMethods != nil {
+		stats.UnknownMethods = make(map[string]int64, len(c.stats.UnknownMethods))
+		for method, n := range c.stats.UnknownMethods {
+			stats.UnknownMethods[method] = n
+		}
+	}
+
+	return stats
+}
+
+// pingRTTWindow is the number of ping round-trip times the average is
+// computed over.
+const pingRTTWindow = 10
+
+func (c *WebsocketClient) recordPingRTT(rtt time.Duration) {
+	c.state.Lock()
+	defer c.state.Unlock()
+
+	c.pingRTTs = append(c.pingRTTs, rtt)
+	if len(c.pingRTTs) > pingRTTWindow {
+		c.pingRTTs = c.pingRTTs[1:]
+	}
+}
+
+// LastPingRTT returns the round-trip time of the last successful SignalR ping,
+// or zero if there was none yet.
+// Pings are sent once a minute by default, see WithPingInterval.
+func (c *WebsocketClient) LastPingRTT() time.Duration {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	if len(c.pingRTTs) == 0 {
+		return 0
+	}
+	return c.pingRTTs[len(c.pingRTTs)-1]
+}
+
+// AveragePingRTT returns the average round-trip time of the last few
+// successful SignalR pings, or zero if there were none yet.
+func (c *WebsocketClient) AveragePingRTT() time.Duration {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	if len(c.pingRTTs) == 0 {
+		return 0
+	}
+
+	var total time.Duration
+	for _, rtt := range c.pingRTTs {
+		total += rtt
+	}
+	return total / time.Duration(len(c.pingRTTs))
+}
+
+// Wait blocks until the connection has been closed and all of its goroutines
+// have exited.
+// It returns the last error observed on the connection, or nil if there was
+// none.
+func (c *WebsocketClient) Wait() error {
+	<-c.done
+
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	return c.lastErr
+}
+
+// initTimeout returns the time to wait for the SignalR init message, as
+// advertised by the server during negotiation.
+func initTimeout(negResp *NegotiateResponse) time.Duration {
+	d := time.Duration(negResp.TransportConnectionTimeout.IntPart()) * time.Second
+	if d <= 0 {
+		d = 5 * time.Second
+	}
+	return d
+}
+
+// silenceTimeout returns the time after which a connection on which nothing
+// was received is considered broken, i.e., the disconnect timeout advertised
+// by the server during negotiation, or its keep-alive timeout if there is
+// none.
+func silenceTimeout(negResp *NegotiateResponse) time.Duration {
+	d := time.Duration(negResp.DisconnectTimeout.IntPart()) * time.Second
+	if d <= 0 {
+		d = time.Duration(negResp.KeepAliveTimeout.IntPart()) * time.Second
+	}
+	return d
+}
+
+// awaitInit reads frames off the websocket until SignalR signals that the
+// connection is initialized, or the timeout expires.
+// This must be called before the read loop is started.
+func (c *WebsocketClient) awaitInit(timeout time.Duration) error {
+	c.ws.SetReadDeadline(time.Now().Add(timeout))
+	defer c.ws.SetReadDeadline(time.Time{})
+
+	for {
+		messageType, b, err := c.ws.ReadMessage()
+		if err != nil {
+			return errors.Wrap(err, "did not receive init message")
+		}
+		if c.debug {
+			c.logger.WithFields(log.Fields{"messageType": messageType, "b": string(b)}).Debugln("websocket read (init)")
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		r, err := parseFrame(b)
+		if err != nil {
+			c.logger.WithField("err", err).Warnln("unable to parse message")
+			continue
+		}
 
-		for {
+		c.observe(r)
+		if r.IsInit() {
+			return nil
+		}
+	}
+}
+
+// frameBufferSize is the number of frames buffered by the read loop.
+const frameBufferSize = 64
+
+// A frame is a frame read off the connection.
+type frame struct {
+	messageType int
+	b           []byte
+	receivedAt  time.Time
+}
+
+// readLoop reads frames off the connection until it fails or is closed.
+// Connection-level information, like invocation results, is processed right
+// away, so that it is not delayed by slow readers.
+// Keep-alive frames and invocation results are not queued for readers.
+// The loop never blocks on readers: if the frame buffer is full, frames are
+// dropped and counted in WSStats.DroppedFrames.
+func (c *WebsocketClient) readLoop() {
+	defer c.wg.Done()
+	defer close(c.readDone)
+	defer close(c.tap)
+
+	for {
+		messageType, b, err := c.ws.ReadMessage()
+		if c.debug {
+			c.logger.WithFields(log.Fields{"messageType": messageType, "b": string(b), "err": err}).Debugln("websocket read")
+		}
+		if err != nil {
 			select {
-			case <-client.closed:
-				t.Stop()
+			case <-c.closed:
 				return
-			case <-t.C:
-				client.wsLock.Lock()
-				err := client.ws.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("{\"H\":\"reportinghub\",\"M\":\"KeepAlive\",\"A\":[],\"I\":%d}", currentNonce)))
-				client.wsLock.Unlock()
+			default:
+			}
 
-				if err != nil {
-					log.WithField("err", err).Errorln("unable to ping WSS")
-					client.err <- errors.Wrap(err, "unable to ping WSS")
-				}
+			c.fail(err)
+			return
+		}
 
-				currentNonce++
+		c.tapFrame(b)
+
+		var (
+			parseErr error
+			unknown  []string
+		)
+		queue := true
+		if messageType == websocket.TextMessage {
+			var r *RawMessageContainer
+			r, parseErr = parseFrame(b)
+			if parseErr == nil {
+				c.observe(r)
+				queue = !r.IsKeepAlive() && !r.isResultOnly()
+				for _, m := range r.Messages {
+					if !isKnownMethod(m.Method) {
+						unknown = append(unknown, m.Method)
+					}
+				}
 			}
 		}
-	}()
-
-	return &client, nil
+		now := time.Now()
+		dropped := false
+		if queue {
+			select {
+			case c.frames <- frame{messageType: messageType, b: b, receivedAt: now}:
+			default:
+				dropped = true
+			}
+		}
+		c.updateStats(func(s *WSStats) {
+			c.lastReceived = now
+			s.FramesRead++
+			s.BytesReceived += int64(len(b))
+			if parseErr != nil {
+				s.ParseErrors++
+			}
+			if dropped {
+				s.DroppedFrames++
+			}
+			for _, method := range unknown {
+				if s.UnknownMethods == nil {
+					s.UnknownMethods = make(map[string]int64)
+				}
+				s.UnknownMethods[method]++
+			}
+		})
+		if dropped {
+			c.logger.Warnln("frame buffer full, dropping frame")
+		}
+	}
 }
 
-func (c *WebsocketClient) close() {
-	log.Debugln("websocket close() called")
-	select {
-	case <-c.closed:
+// tapBufferSize is the number of frames buffered for the tap channel.
+const tapBufferSize = 256
+
+// tapFrame duplicates a frame onto the tap channel, if tapping is enabled.
+// It never blocks, frames are dropped if the channel is full.
+func (c *WebsocketClient) tapFrame(b []byte) {
+	if atomic.LoadInt32(&c.tapEnabled) == 0 {
 		return
+	}
+
+	select {
+	case c.tap <- b:
 	default:
+		atomic.AddInt64(&c.droppedTaps, 1)
 	}
-	close(c.closed)
-	c.wg.Wait()
+}
 
-	c.wsLock.Lock()
-	c.ws.Close()
-	c.wsLock.Unlock()
+// Tap returns a channel that receives a copy of every raw frame read off the
+// connection, including uninteresting ones.
+// This is useful to record traffic for reverse-engineering.
+// Frames are dropped if the channel is not drained quickly enough, see
+// DroppedTaps.
+// The channel is closed once the connection is closed or broken.
+func (c *WebsocketClient) Tap() <-chan []byte {
+	atomic.StoreInt32(&c.tapEnabled, 1)
+	return c.tap
+}
 
-	close(c.err)
+// DroppedTaps returns the number of frames that were dropped because the tap
+// channel was full.
+func (c *WebsocketClient) DroppedTaps() int {
+	return int(atomic.LoadInt64(&c.droppedTaps))
+}
+
+// observe records connection-level information carried by a container.
+func (c *WebsocketClient) observe(r *RawMessageContainer) {
+	c.state.Lock()
+	defer c.state.Unlock()
+
+	if r.IsInit() {
+		c.initialized = true
+	}
+	if r.GroupsToken != "" {
+		c.groupsToken = r.GroupsToken
+	}
+	if r.InvocationID != "" {
+		if ch, ok := c.pending[r.InvocationID]; ok {
+			delete(c.pending, r.InvocationID)
+			ch <- r
+		}
+	}
+	c.notifyStateWatchers(r)
+}
+
+// maxSeenCursors is the number of message cursors remembered for
+// deduplication.
+const maxSeenCursors = 256
+
+// markSeen records the cursor of r and returns whether it had been seen
+// before.
+func (c *WebsocketClient) markSeen(r *RawMessageContainer) bool {
+	if r.Channel == "" {
+		return false
+	}
+
+	c.state.Lock()
+	defer c.state.Unlock()
+
+	if _, ok := c.seenCursors[r.Channel]; ok {
+		return true
+	}
+
+	c.seenCursors[r.Channel] = struct{}{}
+	c.cursorLog = append(c.cursorLog, r.Channel)
+	if len(c.cursorLog) > maxSeenCursors {
+		delete(c.seenCursors, c.cursorLog[0])
+		c.cursorLog = c.cursorLog[1:]
+	}
+	c.lastCursor = r.Channel
+
+	return false
+}
+
+// LastCursor returns the cursor of the last interesting message container
+// returned by ReadNextInterestingMessages.
+func (c *WebsocketClient) LastCursor() string {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	return c.lastCursor
+}
+
+// Initialized returns whether SignalR has signalled that the connection is
+// fully established.
+func (c *WebsocketClient) Initialized() bool {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	return c.initialized
+}
+
+// GroupsToken returns the most recent groups token sent by the server, or the
+// empty string if none was received yet.
+// The token is sent back to the server by ReconnectWebsocket, in order to
+// rejoin the same groups.
+func (c *WebsocketClient) GroupsToken() string {
+	c.state.RLock()
+	defer c.state.RUnlock()
+
+	return c.groupsToken
 }
 
 // A RawMessageContainer contains RawMessages from the Live API.
 type RawMessageContainer struct {
-	Channel  string       `json:"C"`
-	Messages []RawMessage `json:"M"`
+	Channel     string       `json:"C"`
+	Initialized int          `json:"S"`
+	GroupsToken string       `json:"G"`
+	Messages    []RawMessage `json:"M"`
+
+	// These are set for results of hub invocations, see Invoke.
+	InvocationID string          `json:"I"`
+	Result       json.RawMessage `json:"R"`
+	Error        string          `json:"E"`
+
+	// ReceivedAt is the time the frame was read off the connection.
+	// It is set by ReadNextInterestingMessages and DrainAndCoalesce, for
+	// the latter to the time the last frame was read.
+	ReceivedAt time.Time `json:"-"`
+}
+
+// IsInit returns whether the container is the SignalR init message, which is
+// sent once after the connection has been established.
+func (c RawMessageContainer) IsInit() bool {
+	return c.Initialized == 1
+}
+
+// IsKeepAlive returns whether the container is a keep-alive frame, i.e., an
+// empty frame or an empty object like {}.
+func (c RawMessageContainer) IsKeepAlive() bool {
+	return c.Channel == "" && c.Initialized == 0 && c.GroupsToken == "" && len(c.Messages) == 0 && c.InvocationID == ""
+}
+
+// isResultOnly returns whether the container is the result of a hub
+// invocation and carries no messages.
+func (c RawMessageContainer) isResultOnly() bool {
+	return c.InvocationID != "" && len(c.Messages) == 0
 }
 
 func (c RawMessageContainer) isInteresting() bool {
 	split := strings.Split(c.Channel, ",")
-	if len(split) != 5 {
+	if len(split) != 5 || split[2] == "" || split[3] == "" {
 		return false
 	}
 
 	id1, err := strconv.Atoi(split[2][:1])
 	if err != nil {
-		log.WithField("err", err).Warnln("unable to parse message ID 1")
 		return false
 	}
 
 	id2, err := strconv.Atoi(split[3][:1])
 	if err != nil {
-		log.WithField("err", err).Warnln("unable to parse message ID 2")
 		return false
 	}
 
@@ -172,9 +817,12 @@ type RawMessage struct {
 	Arguments []json.RawMessage `json:"A"`
 }
 
-func parseMessage(b []byte) (*RawMessageContainer, error) {
-	if len(b) < 10 {
-		return nil, errors.New("message too short")
+// parseFrame parses a frame.
+// Empty frames are treated as keep-alive frames, see
+// RawMessageContainer.IsKeepAlive.
+func parseFrame(b []byte) (*RawMessageContainer, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return &RawMessageContainer{}, nil
 	}
 
 	var r RawMessageContainer
@@ -186,67 +834,267 @@ func parseMessage(b []byte) (*RawMessageContainer, error) {
 	return &r, nil
 }
 
-func isInterestingChannel(b []byte) bool {
-	if len(b) < 10 {
-		return false
-	}
-
-	var r RawMessageContainer
-	err := json.Unmarshal(b, &r)
-	if err != nil {
-		log.WithField("err", err).Warnln("unable to parse message")
-		return false
-	}
-
-	return r.isInteresting()
-}
-
 // Read reads a message off the websocket.
 // Use ReadNextInterestingMessage instead.
+// Keep-alive frames and invocation results are not returned.
 //
 // This method returns all kinds of errors that concurrently occurred since the
 // last call to Read.
 // If it does return an error, close and re-open the websocket connection.
+// Once the connection has been closed, ErrWebsocketClosed is returned.
 func (c *WebsocketClient) Read() (messageType int, b []byte, err error) {
+	return c.readContext(context.Background())
+}
+
+func (c *WebsocketClient) readContext(ctx context.Context) (messageType int, b []byte, err error) {
+	f, err := c.nextFrame(ctx)
+	return f.messageType, f.b, err
+}
+
+// nextFrame returns the next frame read by the read loop.
+func (c *WebsocketClient) nextFrame(ctx context.Context) (frame, error) {
 	select {
-	case <-c.closed:
-		return 0, nil, errors.New("ws closed")
 	case err := <-c.err:
-		return 0, nil, errors.Wrap(err, "connection broken")
+		return frame{}, errors.Wrap(err, "connection broken")
+	case <-c.closed:
+		return frame{}, c.closedErr()
 	default:
 	}
 
-	c.wsLock.Lock()
-	messageType, b, err = c.ws.ReadMessage()
-	c.wsLock.Unlock()
-
-	if c.debug {
-		log.WithFields(log.Fields{"messageType": messageType, "b": string(b), "err": err}).Debugln("websocket read")
+	select {
+	case err := <-c.err:
+		return frame{}, errors.Wrap(err, "connection broken")
+	case <-c.closed:
+		return frame{}, c.closedErr()
+	case <-ctx.Done():
+		return frame{}, ctx.Err()
+	case f := <-c.frames:
+		return f, nil
+	case <-c.readDone:
+		// The read loop exited because the connection failed or was closed.
+		return frame{}, c.closedErr()
 	}
-
-	return
 }
 
 // ReadNextInterestingMessages reads messages off the websocket until an
 // interesting message comes by.
+// Messages with a cursor that has been seen recently, e.g., due to SignalR
+// replaying buffered messages, are skipped.
+// Once the connection has been closed, an error matching ErrWebsocketClosed is
+// returned.
 func (c *WebsocketClient) ReadNextInterestingMessages() (*RawMessageContainer, error) {
+	return c.ReadNextInterestingMessagesContext(context.Background())
+}
+
+// ReadNextInterestingMessagesContext is like ReadNextInterestingMessages, but
+// stops waiting when the context is done, returning the context's error.
+// The connection remains usable afterwards.
+func (c *WebsocketClient) ReadNextInterestingMessagesContext(ctx context.Context) (*RawMessageContainer, error) {
 	for {
-		mType, b, err := c.Read()
+		f, err := c.nextFrame(ctx)
+		if err == ctx.Err() && err != nil {
+			return nil, err
+		}
 		if err != nil {
 			return nil, errors.Wrap(err, "read failed")
 		}
-		if mType != websocket.TextMessage {
+
+		parsed := c.interesting(f)
+		if parsed == nil {
+			continue
+		}
+
+		return parsed, nil
+	}
+}
+
+// interesting parses a frame and returns it if it is interesting and has not
+// been seen before, or nil otherwise.
+func (c *WebsocketClient) interesting(f frame) *RawMessageContainer {
+	if f.messageType != websocket.TextMessage {
+		return nil
+	}
+
+	parsed, err := parseFrame(f.b)
+	if err != nil {
+		c.logger.WithField("err", err).Warnln("unable to parse message")
+		return nil
+	}
+	if parsed.IsKeepAlive() {
+		return nil
+	}
+
+	if !parsed.isInteresting() {
+		return nil
+	}
+	parsed.ReceivedAt = f.receivedAt
+	if c.markSeen(parsed) {
+		if c.debug {
+			c.logger.WithField("cursor", parsed.Channel).Debugln("skipping already seen message")
+		}
+		return nil
+	}
+
+	parsed = c.filterMachines(parsed)
+	if parsed == nil {
+		return nil
+	}
+	c.updateStats(func(s *WSStats) {
+		s.InterestingFrames++
+	})
+
+	return parsed
+}
+
+// DrainAndCoalesce reads the next interesting container, see
+// ReadNextInterestingMessages, and then all interesting containers that are
+// already buffered, without blocking.
+// The messages are returned in a single container, in which StatusChanged
+// messages for the same device are coalesced, keeping only the latest.
+// The cursor of the returned container is that of the last container read.
+//
+// This is useful to reduce processing spikes after reconnecting, when a burst
+// of buffered messages arrives.
+func (c *WebsocketClient) DrainAndCoalesce() (*RawMessageContainer, error) {
+	first, err := c.ReadNextInterestingMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	drained := *first
+	drained.Messages = append([]RawMessage(nil), first.Messages...)
+	for {
+		var f frame
+		select {
+		case f = <-c.frames:
+		default:
+			drained.Messages = coalesceStatusChanges(drained.Messages)
+			return &drained, nil
+		}
+
+		parsed := c.interesting(f)
+		if parsed == nil {
+			continue
+		}
+		drained.Channel = parsed.Channel
+		drained.ReceivedAt = parsed.ReceivedAt
+		if parsed.GroupsToken != "" {
+			drained.GroupsToken = parsed.GroupsToken
+		}
+		drained.Messages = append(drained.Messages, parsed.Messages...)
+	}
+}
+
+// coalesceStatusChanges removes all but the last StatusChanged message for
+// every device, keeping the order of the remaining messages.
+// Messages that can not be parsed are kept.
+func coalesceStatusChanges(messages []RawMessage) []RawMessage {
+	type device struct{ machineSID, deviceID string }
+
+	seen := make(map[device]struct{})
+	keep := make([]bool, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		keep[i] = true
+		if messages[i].Method != MethodStatusChanged {
 			continue
 		}
-		if !isInterestingChannel(b) {
+
+		msg, err := ParseStatusChangedMessage(messages[i])
+		if err != nil {
+			continue
+		}
+		d := device{msg.MachineSID, msg.DeviceID}
+		if _, ok := seen[d]; ok {
+			keep[i] = false
+		}
+		seen[d] = struct{}{}
+	}
+
+	coalesced := messages[:0]
+	for i, m := range messages {
+		if keep[i] {
+			coalesced = append(coalesced, m)
+		}
+	}
+	return coalesced
+}
+
+// ReadNextEvents reads the next interesting container, see
+// ReadNextInterestingMessages, and parses its messages using ParseMessage.
+// Messages with unknown methods are returned as *UnknownMessage, messages
+// that can not be parsed are logged and skipped.
+func (c *WebsocketClient) ReadNextEvents() ([]interface{}, error) {
+	return c.ReadNextEventsContext(context.Background())
+}
+
+// ReadNextEventsContext is like ReadNextEvents, but returns ctx.Err() once
+// the context is done.
+func (c *WebsocketClient) ReadNextEventsContext(ctx context.Context) ([]interface{}, error) {
+	container, err := c.ReadNextInterestingMessagesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]interface{}, 0, len(container.Messages))
+	for _, msg := range container.Messages {
+		event, err := ParseMessage(msg)
+		if err != nil {
+			c.logger.WithFields(log.Fields{"method": msg.Method, "err": err}).Warnln("unable to parse message")
 			continue
 		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
 
-		parsed, err := parseMessage(b)
+// Tail reads interesting containers, see ReadNextInterestingMessages, and
+// writes every message as a JSON line to w, until the context is done or
+// reading or writing fails.
+// Each line holds the time the container was received, the method and the
+// message parsed using ParseMessage.
+// Messages that can not be parsed are logged and skipped.
+// If w has a Flush method, e.g. a *bufio.Writer or an http.Flusher, it is
+// called after every line.
+func (c *WebsocketClient) Tail(ctx context.Context, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for {
+		container, err := c.ReadNextInterestingMessagesContext(ctx)
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to parse message")
+			return err
 		}
 
-		return parsed, nil
+		for _, msg := range container.Messages {
+			event, err := ParseMessage(msg)
+			if err != nil {
+				c.logger.WithFields(log.Fields{"method": msg.Method, "err": err}).Warnln("unable to parse message")
+				continue
+			}
+
+			err = enc.Encode(struct {
+				ReceivedAt time.Time   `json:"receivedAt"`
+				Method     string      `json:"method"`
+				Event      interface{} `json:"event"`
+			}{container.ReceivedAt, msg.Method, event})
+			if err != nil {
+				return errors.Wrap(err, "unable to write event")
+			}
+
+			err = flush(w)
+			if err != nil {
+				return errors.Wrap(err, "unable to flush")
+			}
+		}
+	}
+}
+
+// flush flushes w, if it supports flushing.
+func flush(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
 	}
+	return nil
 }
@@ -0,0 +1,215 @@
+package winminer_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mrd0ll4r/winminer"
+	"github.com/mrd0ll4r/winminer/winminertest"
+)
+
+func newTestClient(t *testing.T, s *winminertest.Server, opts ...winminer.Option) *winminer.APIClient {
+	t.Helper()
+
+	opts = append([]winminer.Option{winminer.WithBaseURL(s.URL)}, opts...)
+	c, err := winminer.NewAPIClient("test@example.com", "password", false, opts...)
+	if err != nil {
+		t.Fatalf("unable to create client: %s", err)
+	}
+	t.Cleanup(func() { c.CloseWebsocket() })
+
+	return c
+}
+
+// waitFor polls cond until it returns true or a timeout expires.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// confirmNextInvocation pushes the given messages once the server received
+// the next frame from the client.
+// The fake server only acknowledges invocations, so this is used to send the
+// messages the real server would send in response.
+func confirmNextInvocation(s *winminertest.Server, messages ...winminer.RawMessage) {
+	received := len(s.Received())
+	go func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(s.Received()) == received && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		s.PushMessages(messages...)
+	}()
+}
+
+func TestReadLoopDoesNotBlockInvoke(t *testing.T) {
+	s := winminertest.NewServer()
+	defer s.Close()
+	c := newTestClient(t, s, winminer.WithSetStateMethod("SetState"))
+
+	ws, err := c.ConnectWebsocket()
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+
+	const unread = 70
+	for i := 0; i < unread; i++ {
+		s.PushRaw([]byte(`{}`))
+	}
+	for i := 0; i < unread; i++ {
+		err = s.PushMessages(s.Message(winminer.MethodStatusChanged, "sid", "device", winminer.StatusMining))
+		if err != nil {
+			t.Fatalf("unable to push: %s", err)
+		}
+	}
+	waitFor(t, "frames to be read", func() bool {
+		return ws.Stats().FramesRead >= 2*unread
+	})
+
+	confirmNextInvocation(s, s.Message(winminer.MethodStateChanged, "sid", "device", true))
+	err = c.SetDeviceEnabled("sid", "device", true)
+	if err != nil {
+		t.Fatalf("SetDeviceEnabled failed with %d unread frames: %s", 2*unread, err)
+	}
+
+	if ws.Stats().DroppedFrames == 0 {
+		t.Errorf("expected frames to be dropped")
+	}
+}
+
+func TestReconnectResumes(t *testing.T) {
+	s := winminertest.NewServer()
+	defer s.Close()
+	c := newTestClient(t, s)
+
+	ws, err := c.ConnectWebsocket()
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+
+	const groupsToken = "test-groups-token"
+	err = s.Push(winminer.RawMessageContainer{GroupsToken: groupsToken})
+	if err != nil {
+		t.Fatalf("unable to push: %s", err)
+	}
+	status := s.Message(winminer.MethodStatusChanged, "sid", "device", winminer.StatusMining)
+	err = s.PushMessages(status)
+	if err != nil {
+		t.Fatalf("unable to push: %s", err)
+	}
+	first, err := ws.ReadNextInterestingMessages()
+	if err != nil {
+		t.Fatalf("unable to read: %s", err)
+	}
+
+	ws, err = c.ReconnectWebsocket()
+	if err != nil {
+		t.Fatalf("unable to reconnect: %s", err)
+	}
+
+	connects := s.Connects()
+	if len(connects) != 2 {
+		t.Fatalf("expected 2 connects, got %d", len(connects))
+	}
+	if got := connects[1].Get("messageId"); got != first.Channel {
+		t.Errorf("expected reconnect to resume from %q, got %q", first.Channel, got)
+	}
+	if got := connects[1].Get("groupsToken"); got != groupsToken {
+		t.Errorf("expected reconnect to send groups token %q, got %q", groupsToken, got)
+	}
+
+	// SignalR replays the message, followed by a new one.
+	err = s.Push(*first)
+	if err != nil {
+		t.Fatalf("unable to push: %s", err)
+	}
+	err = s.PushMessages(status)
+	if err != nil {
+		t.Fatalf("unable to push: %s", err)
+	}
+
+	next, err := ws.ReadNextInterestingMessages()
+	if err != nil {
+		t.Fatalf("unable to read: %s", err)
+	}
+	if next.Channel == first.Channel {
+		t.Errorf("replayed message %q was not skipped", next.Channel)
+	}
+}
+
+func TestMaxFrameSizeAfterDecompression(t *testing.T) {
+	s := winminertest.NewServer()
+	defer s.Close()
+	c := newTestClient(t, s, winminer.WithMaxFrameSize(16<<10))
+
+	ws, err := c.ConnectWebsocket()
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+
+	// Compresses to far less than the limit on the wire.
+	frame := append([]byte(`{"C":"`), bytes.Repeat([]byte("a"), 1<<20)...)
+	frame = append(frame, `"}`...)
+	s.PushRaw(frame)
+	err = s.PushMessages(s.Message(winminer.MethodStatusChanged, "sid", "device", winminer.StatusMining))
+	if err != nil {
+		t.Fatalf("unable to push: %s", err)
+	}
+
+	_, err = ws.ReadNextInterestingMessages()
+	if !errors.Is(err, winminer.ErrFrameTooLarge) {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestStatsCountUnknownMethods(t *testing.T) {
+	s := winminertest.NewServer()
+	defer s.Close()
+	c := newTestClient(t, s)
+
+	ws, err := c.ConnectWebsocket()
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+
+	err = s.PushMessages(
+		s.Message("NewMethod", "sid"),
+		s.Message(winminer.MethodStatusChanged, "sid", "device", winminer.StatusMining),
+		s.Message("NewMethod"),
+		s.Message("OtherMethod"),
+	)
+	if err != nil {
+		t.Fatalf("unable to push: %s", err)
+	}
+	container, err := ws.ReadNextInterestingMessages()
+	if err != nil {
+		t.Fatalf("unable to read: %s", err)
+	}
+	msg, err := winminer.ParseMessage(container.Messages[0])
+	if err != nil {
+		t.Fatalf("unable to parse: %s", err)
+	}
+	if m, ok := msg.(*winminer.UnknownMessage); !ok || m.Method != "NewMethod" {
+		t.Errorf("expected an UnknownMessage for NewMethod, got %#v", msg)
+	}
+
+	stats := ws.Stats()
+	if stats.UnknownMethods["NewMethod"] != 2 || stats.UnknownMethods["OtherMethod"] != 1 || len(stats.UnknownMethods) != 2 {
+		t.Errorf("unexpected unknown method counts %v", stats.UnknownMethods)
+	}
+
+	// The returned counts are a copy.
+	stats.UnknownMethods["NewMethod"] = 0
+	if ws.Stats().UnknownMethods["NewMethod"] != 2 {
+		t.Errorf("modifying the returned stats changed the counts")
+	}
+}
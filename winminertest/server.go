@@ -0,0 +1,346 @@
+// Package winminertest provides an in-memory fake of the WinMiner API and its
+// SignalR hub, for end-to-end tests of code using the winminer package.
+//
+// Only the endpoints required to log in, list machines and connect to the
+// Live API via WebSockets are implemented.
+// Long polling is not supported.
+package winminertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/mrd0ll4r/winminer"
+)
+
+// Tokens and IDs returned by the Server.
+const (
+	UserToken       = "test-user-token"
+	HubToken        = "test-hub-token"
+	ConnectionToken = "test-connection-token"
+	ConnectionID    = "test-connection-id"
+)
+
+// DefaultHubName is the name of the hub served by a Server, unless changed
+// with SetHubName.
+const DefaultHubName = "reportinghub"
+
+// frameBufferSize is the number of pushed frames buffered until a client
+// connects.
+const frameBufferSize = 64
+
+// A Server is a fake WinMiner API and SignalR hub.
+// Point a client at it using winminer.WithBaseURL(s.URL).
+// Frames pushed via Push, PushMessages or PushRaw are delivered to the
+// connected websocket, or buffered until a client connects.
+type Server struct {
+	*httptest.Server
+
+	frames chan []byte
+	done   chan struct{}
+	cursor int64
+
+	lock     sync.Mutex
+	hubName  string
+	machines winminer.MachinesResponse
+	received [][]byte
+	connects []url.Values
+	conns    map[*websocket.Conn]struct{}
+
+	closeOnce sync.Once
+}
+
+// NewServer starts a new Server.
+// Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		hubName: DefaultHubName,
+		frames:  make(chan []byte, frameBufferSize),
+		done:    make(chan struct{}),
+		conns:   make(map[*websocket.Conn]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/user/login", s.handleLogin)
+	mux.HandleFunc("/hub/auth2", s.handleAuth2)
+	mux.HandleFunc("/hub/machines", s.handleMachines)
+	mux.HandleFunc("/signalr/negotiate", s.handleNegotiate)
+	mux.HandleFunc("/signalr/connect", s.handleConnect)
+	mux.HandleFunc("/signalr/start", s.handleGeneric("started"))
+	mux.HandleFunc("/signalr/ping", s.handleGeneric("pong"))
+	mux.HandleFunc("/signalr/abort", func(w http.ResponseWriter, r *http.Request) {})
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Close disconnects all clients and shuts the server down.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.Disconnect()
+		s.Server.Close()
+	})
+}
+
+// SetHubName sets the name of the hub served, see winminer.WithHubName.
+// Negotiating and connecting fail for other hubs, and messages constructed
+// with Message are sent from this hub.
+func (s *Server) SetHubName(name string) {
+	s.lock.Lock()
+	s.hubName = name
+	s.lock.Unlock()
+}
+
+func (s *Server) currentHubName() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.hubName
+}
+
+// SetMachines sets the machines returned by the machines endpoint.
+func (s *Server) SetMachines(machines winminer.MachinesResponse) {
+	s.lock.Lock()
+	s.machines = machines
+	s.lock.Unlock()
+}
+
+// Received returns the frames received from clients so far, e.g. hub
+// invocations.
+func (s *Server) Received() [][]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	received := make([][]byte, len(s.received))
+	copy(received, s.received)
+	return received
+}
+
+// Connects returns the query parameters of all connect requests so far, e.g.
+// to check the cursor a client resumes from.
+func (s *Server) Connects() []url.Values {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	connects := make([]url.Values, len(s.connects))
+	copy(connects, s.connects)
+	return connects
+}
+
+// Disconnect closes all websocket connections, e.g. to test reconnecting.
+func (s *Server) Disconnect() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// Message constructs a RawMessage from the hub with the given method and
+// arguments, which are encoded as JSON.
+// It panics if an argument can not be encoded.
+func (s *Server) Message(method string, args ...interface{}) winminer.RawMessage {
+	m := winminer.RawMessage{Host: s.currentHubName(), Method: method}
+	for _, arg := range args {
+		b, err := json.Marshal(arg)
+		if err != nil {
+			panic(fmt.Sprintf("unable to encode argument: %s", err))
+		}
+		m.Arguments = append(m.Arguments, b)
+	}
+	return m
+}
+
+// Push sends a container to the client as-is.
+func (s *Server) Push(c winminer.RawMessageContainer) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	s.PushRaw(b)
+	return nil
+}
+
+// PushMessages sends the given messages to the client, in a container with a
+// fresh cursor that winminer considers interesting.
+func (s *Server) PushMessages(messages ...winminer.RawMessage) error {
+	n := atomic.AddInt64(&s.cursor, 1)
+	return s.Push(winminer.RawMessageContainer{
+		Channel:  fmt.Sprintf("d-%d,0|A,2|B,2|C,%d", n, n),
+		Messages: messages,
+	})
+}
+
+// PushRaw sends a raw frame to the client.
+// It blocks if too many frames are buffered and no client is connected.
+func (s *Server) PushRaw(b []byte) {
+	select {
+	case s.frames <- b:
+	case <-s.done:
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, winminer.LoginResponse{UserToken: UserToken})
+}
+
+func (s *Server) handleAuth2(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+UserToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, winminer.Auth2Response{Host: s.URL, Token: HubToken})
+}
+
+func (s *Server) handleMachines(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+UserToken {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.lock.Lock()
+	machines := s.machines
+	s.lock.Unlock()
+	if machines == nil {
+		machines = winminer.MachinesResponse{}
+	}
+
+	writeJSON(w, machines)
+}
+
+// checkHub checks that the connectionData of a request names the hub served.
+func (s *Server) checkHub(w http.ResponseWriter, r *http.Request) bool {
+	var hubs []struct {
+		Name string `json:"name"`
+	}
+	err := json.Unmarshal([]byte(r.URL.Query().Get("connectionData")), &hubs)
+	if err != nil || len(hubs) != 1 || !strings.EqualFold(hubs[0].Name, s.currentHubName()) {
+		http.Error(w, "unknown hub", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+func (s *Server) handleNegotiate(w http.ResponseWriter, r *http.Request) {
+	if !s.checkHub(w, r) {
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"Url":                        "/signalr",
+		"ConnectionToken":            ConnectionToken,
+		"ConnectionId":               ConnectionID,
+		"KeepAliveTimeout":           20,
+		"DisconnectTimeout":          30,
+		"ConnectionTimeout":          110,
+		"TryWebSockets":              true,
+		"ProtocolVersion":            "1.5",
+		"TransportConnectionTimeout": 5,
+		"LongPollDelay":              0,
+	})
+}
+
+func (s *Server) handleGeneric(response string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, winminer.GenericSignalrResponse{Response: response})
+	}
+}
+
+var upgrader = websocket.Upgrader{EnableCompression: true}
+
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("connectionToken") != ConnectionToken {
+		http.Error(w, "invalid connection token", http.StatusBadRequest)
+		return
+	}
+	if !s.checkHub(w, r) {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	s.lock.Lock()
+	s.connects = append(s.connects, r.URL.Query())
+	s.conns[conn] = struct{}{}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+	}()
+
+	var writeLock sync.Mutex
+	write := func(b []byte) error {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		return conn.WriteMessage(websocket.TextMessage, b)
+	}
+
+	err = write([]byte(`{"C":"s-0,0|A,0|B,0|C,0","S":1,"M":[]}`))
+	if err != nil {
+		return
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		s.readLoop(conn, write)
+	}()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-closed:
+			return
+		case b := <-s.frames:
+			err = write(b)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLoop records the frames sent by the client and acknowledges hub
+// invocations with an empty result.
+func (s *Server) readLoop(conn *websocket.Conn, write func([]byte) error) {
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		s.lock.Lock()
+		s.received = append(s.received, b)
+		s.lock.Unlock()
+
+		var inv struct {
+			ID string `json:"I"`
+		}
+		if json.Unmarshal(b, &inv) == nil && inv.ID != "" {
+			write([]byte(fmt.Sprintf(`{"I":%q}`, inv.ID)))
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package winminer
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+)
+
+// A FeeBreakdown lists the fees deducted from a gross amount, in USD.
+type FeeBreakdown struct {
+	Gross          decimal.Decimal
+	WinMinerFee    decimal.Decimal
+	ProviderFee    decimal.Decimal
+	WithholdingTax decimal.Decimal
+	Net            decimal.Decimal
+}
+
+// percent returns p percent of amount.
+func percent(amount, p decimal.Decimal) decimal.Decimal {
+	return amount.Mul(p).Div(decimal.New(100, 0))
+}
+
+// breakdown computes the fees deducted from a gross amount in USD, using the
+// given provider fee.
+// The WinMiner fee and the withholding tax are percentages of the gross
+// amount, the provider fee is either a flat amount or a percentage, depending
+// on fixed.
+func (f FeeEntry) breakdown(gross, providerFee decimal.Decimal, fixed bool) FeeBreakdown {
+	b := FeeBreakdown{
+		Gross:          gross,
+		WinMinerFee:    percent(gross, f.WinMinerFee),
+		WithholdingTax: percent(gross, f.WithholdingTax),
+		ProviderFee:    providerFee,
+	}
+	if !fixed {
+		b.ProviderFee = percent(gross, providerFee)
+	}
+
+	b.Net = gross.Sub(b.WinMinerFee).Sub(b.WithholdingTax).Sub(b.ProviderFee)
+	return b
+}
+
+// A FeeTier is a provider fee tier, see FeeEntry.NetPayout.
+type FeeTier string
+
+// Provider fee tiers.
+const (
+	FeeTierLow    FeeTier = "low"
+	FeeTierNormal FeeTier = "normal"
+	FeeTierHigh   FeeTier = "high"
+)
+
+// ProviderFeeForTier returns the provider fee of the given tier.
+// Returns false for unknown tiers, tiers are case-sensitive.
+func (f FeeEntry) ProviderFeeForTier(tier FeeTier) (decimal.Decimal, bool) {
+	switch tier {
+	case FeeTierLow:
+		return f.ProviderLowFee, true
+	case FeeTierNormal:
+		return f.ProviderFee, true
+	case FeeTierHigh:
+		return f.ProviderHighFee, true
+	default:
+		return decimal.Decimal{}, false
+	}
+}
+
+// NetPayout returns the amount in USD paid out when withdrawing gross USD,
+// after deducting the WinMiner fee, the withholding tax, and the provider fee
+// of the given tier, i.e., FeeTierLow, FeeTierNormal or FeeTierHigh.
+// The WinMiner fee and the withholding tax are percentages of the gross
+// amount.
+// The provider fee is a flat amount if fixed is set, and a percentage
+// otherwise. Pass ProviderFixedFee unless you know better.
+// The result may be negative if the fees exceed the gross amount.
+// It fails for unknown tiers.
+func (f FeeEntry) NetPayout(gross decimal.Decimal, tier FeeTier, fixed bool) (decimal.Decimal, error) {
+	providerFee, ok := f.ProviderFeeForTier(tier)
+	if !ok {
+		return decimal.Decimal{}, errors.Errorf("unknown fee tier %q", tier)
+	}
+
+	return f.breakdown(gross, providerFee, fixed).Net, nil
+}
+
+// A WithdrawPreview is the result of PreviewWithdrawal.
+type WithdrawPreview struct {
+	TypeID int
+	FeeBreakdown
+
+	// Currency is the currency the withdrawal is paid out in, e.g. USD for
+	// gift cards.
+	Currency string
+	// Rate is the USD price of one unit of Currency.
+	Rate decimal.Decimal
+	// NetCoin is the net amount in Currency.
+	NetCoin decimal.Decimal
+}
+
+// PreviewWithdrawal computes the fees and the net payout of withdrawing
+// amount USD with the option of the given type ID, paid out in currency, i.e.,
+// USD, BTC, ETH or LTC, based on the current withdraw data.
+// The normal provider fee is used.
+//
+// Withdraw options are not returned by any known endpoint, so they must be
+// provided, e.g. from the website's templates. The currency must be provided
+// as well, as nothing known maps type IDs to currencies.
+// The fees are those of the FeeEntry whose Type is the type ID.
+//
+// It fails if the option is not found or disabled, if the amount is outside
+// its minimum and maximum, not positive, exceeds the balance, or does not
+// cover the fees, or if there is no exchange rate for the currency.
+func (c *APIClient) PreviewWithdrawal(options WithdrawOptions, typeID int, currency string, amount decimal.Decimal) (*WithdrawPreview, error) {
+	option, ok := options.ByTypeID(typeID)
+	if !ok {
+		return nil, errors.Errorf("no withdraw option with type %d", typeID)
+	}
+	if option.Disabled {
+		return nil, errors.Errorf("withdraw option %d is disabled", typeID)
+	}
+	if !amount.IsPositive() {
+		return nil, errors.Errorf("invalid amount %s", amount)
+	}
+	if amount.LessThan(option.MinimumToWithdraw) {
+		return nil, errors.Errorf("amount %s is below the minimum %s", amount, option.MinimumToWithdraw)
+	}
+	if option.MaximumToWithdraw.IsPositive() && amount.GreaterThan(option.MaximumToWithdraw) {
+		return nil, errors.Errorf("amount %s exceeds the maximum %s", amount, option.MaximumToWithdraw)
+	}
+
+	data, err := c.GetWithdrawData()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get withdraw data")
+	}
+	if amount.GreaterThan(data.Balance) {
+		return nil, errors.Errorf("amount %s exceeds balance %s", amount, data.Balance)
+	}
+
+	var fee *FeeEntry
+	for i := range data.Fees {
+		if data.Fees[i].Type == typeID {
+			fee = &data.Fees[i]
+			break
+		}
+	}
+	if fee == nil {
+		return nil, errors.Errorf("no fees for withdraw type %d", typeID)
+	}
+
+	rate, ok := usdRate(currency, data.Exchange)
+	if !ok {
+		return nil, errors.Errorf("no exchange rate for currency %q", currency)
+	}
+
+	p := WithdrawPreview{
+		TypeID:       typeID,
+		FeeBreakdown: fee.breakdown(amount, fee.ProviderFee, fee.ProviderFixedFee),
+		Currency:     strings.ToUpper(currency),
+		Rate:         rate,
+	}
+	if !p.Net.IsPositive() {
+		return nil, errors.Errorf("amount %s does not cover the fees", amount)
+	}
+	p.NetCoin = p.Net.Div(rate)
+
+	return &p, nil
+}
+
+// WithdrawOptions is a list of withdraw options.
+type WithdrawOptions []WithdrawOption
+
+// Enabled returns the options that are not disabled.
+func (o WithdrawOptions) Enabled() WithdrawOptions {
+	var enabled WithdrawOptions
+	for _, opt := range o {
+		if !opt.Disabled {
+			enabled = append(enabled, opt)
+		}
+	}
+
+	return enabled
+}
+
+// ByTypeID returns the option with the given type ID, see TransactionType.
+// Returns false if there is no such option.
+func (o WithdrawOptions) ByTypeID(typeID int) (WithdrawOption, bool) {
+	for _, opt := range o {
+		if opt.TypeID == typeID {
+			return opt, true
+		}
+	}
+
+	return WithdrawOption{}, false
+}
+
+// AffordableGiftCards returns the gift cards whose amount does not exceed the
+// balance, keyed by provider, i.e., apple and amazon, sorted by amount in
+// descending order.
+// Fees are not taken into account, see PreviewWithdrawal.
+func (r *WithdrawDataResponse) AffordableGiftCards() map[string][]GiftCardEntry {
+	return map[string][]GiftCardEntry{
+		"apple":  affordable(r.AppleGiftCards, r.Balance),
+		"amazon": affordable(r.AmazonGiftCards, r.Balance),
+	}
+}
+
+func affordable(cards []GiftCardEntry, balance decimal.Decimal) []GiftCardEntry {
+	var affordable []GiftCardEntry
+	for _, card := range cards {
+		if decimal.New(int64(card.Amount), 0).LessThanOrEqual(balance) {
+			affordable = append(affordable, card)
+		}
+	}
+
+	sort.SliceStable(affordable, func(i, j int) bool {
+		return affordable[i].Amount > affordable[j].Amount
+	})
+
+	return affordable
+}
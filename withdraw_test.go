@@ -0,0 +1,190 @@
+package winminer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// newFixtureClient returns a client whose requests to path are answered with
+// the contents of the given file in testdata.
+// The fixtures are synthetic, they exercise decoding and arithmetic, not the
+// values of the real API.
+func newFixtureClient(t *testing.T, path, file string) *APIClient {
+	t.Helper()
+
+	b, err := os.ReadFile("testdata/" + file)
+	if err != nil {
+		t.Fatalf("unable to read fixture: %s", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+
+	return newAPIClient("", "", false, []Option{WithBaseURL(srv.URL), WithStrictJSON(true)})
+}
+
+// testWithdrawOptions match the fees of testdata/withdraw_data.json.
+var testWithdrawOptions = WithdrawOptions{
+	{TypeID: 1, Disabled: true},
+	{TypeID: 3, MinimumToWithdraw: decimal.New(1, -2), MaximumToWithdraw: decimal.New(20, 0)},
+	{TypeID: 4, MinimumToWithdraw: decimal.New(5, 0)},
+	{TypeID: 5},
+}
+
+func TestPreviewWithdrawal(t *testing.T) {
+	c := newFixtureClient(t, withdrawDataPath, "withdraw_data.json")
+
+	tests := []struct {
+		name     string
+		typeID   int
+		currency string
+		amount   string
+		want     map[string]string
+	}{
+		{"coin", 3, "ltc", "10", map[string]string{
+			"WinMiner fee": "0.5",
+			"provider fee": "0.05",
+			"tax":          "0",
+			"net":          "9.45",
+			"rate":         "62.5",
+			"net coin":     "0.1512",
+		}},
+		{"gift card", 4, "USD", "10", map[string]string{
+			"WinMiner fee": "0.5",
+			"provider fee": "0.2",
+			"tax":          "1",
+			"net":          "8.3",
+			"rate":         "1",
+			"net coin":     "8.3",
+		}},
+	}
+
+	for _, test := range tests {
+		p, err := c.PreviewWithdrawal(testWithdrawOptions, test.typeID, test.currency, decimal.RequireFromString(test.amount))
+		if err != nil {
+			t.Errorf("%s: unable to preview withdrawal: %s", test.name, err)
+			continue
+		}
+
+		got := map[string]decimal.Decimal{
+			"WinMiner fee": p.WinMinerFee,
+			"provider fee": p.ProviderFee,
+			"tax":          p.WithholdingTax,
+			"net":          p.Net,
+			"rate":         p.Rate,
+			"net coin":     p.NetCoin,
+		}
+		for name, want := range test.want {
+			if !got[name].Equal(decimal.RequireFromString(want)) {
+				t.Errorf("%s: expected %s %s, got %s", test.name, name, want, got[name])
+			}
+		}
+		if !p.Gross.Equal(decimal.RequireFromString(test.amount)) || p.TypeID != test.typeID {
+			t.Errorf("%s: unexpected preview %+v", test.name, p)
+		}
+		if p.Currency != strings.ToUpper(test.currency) {
+			t.Errorf("%s: expected currency %s, got %q", test.name, strings.ToUpper(test.currency), p.Currency)
+		}
+	}
+}
+
+func TestPreviewWithdrawalErrors(t *testing.T) {
+	c := newFixtureClient(t, withdrawDataPath, "withdraw_data.json")
+
+	tests := []struct {
+		name     string
+		typeID   int
+		currency string
+		amount   string
+	}{
+		{"unknown option", 42, "LTC", "10"},
+		{"disabled option", 1, "BTC", "10"},
+		{"no fees", 5, "USD", "10"},
+		{"unknown currency", 3, "DOGE", "10"},
+		{"zero amount", 3, "LTC", "0"},
+		{"negative amount", 3, "LTC", "-1"},
+		{"below minimum", 4, "USD", "4.99"},
+		{"above maximum", 3, "LTC", "20.01"},
+		{"amount exceeds balance", 4, "USD", "25.51"},
+		{"amount does not cover fees", 3, "LTC", "0.05"},
+	}
+
+	for _, test := range tests {
+		_, err := c.PreviewWithdrawal(testWithdrawOptions, test.typeID, test.currency, decimal.RequireFromString(test.amount))
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestNetPayout(t *testing.T) {
+	c := newFixtureClient(t, withdrawDataPath, "withdraw_data.json")
+	data, err := c.GetWithdrawData()
+	if err != nil {
+		t.Fatalf("unable to get withdraw data: %s", err)
+	}
+	fees := make(map[int]FeeEntry)
+	for _, fee := range data.Fees {
+		fees[fee.Type] = fee
+	}
+
+	tests := []struct {
+		name  string
+		fee   FeeEntry
+		gross string
+		tier  FeeTier
+		fixed bool
+		net   string
+	}{
+		// 100 - 5% WinMiner fee - 0.05 flat provider fee.
+		{"fixed normal", fees[3], "100", FeeTierNormal, true, "94.95"},
+		{"fixed low", fees[3], "100", FeeTierLow, true, "94.98"},
+		{"fixed high", fees[3], "100", FeeTierHigh, true, "94.9"},
+		// 100 - 5% WinMiner fee - 5% provider fee.
+		{"fixed fee as percentage", fees[3], "100", FeeTierNormal, false, "94.95"},
+		// 50 - 5% WinMiner fee - 10% tax - 2% provider fee.
+		{"percentage with tax", fees[4], "50", FeeTierNormal, false, "41.5"},
+		{"percentage with tax low", fees[4], "50", FeeTierLow, false, "42"},
+		{"percentage with tax high", fees[4], "50", FeeTierHigh, false, "41"},
+		// 50 - 5% WinMiner fee - 10% tax - 2 flat provider fee.
+		{"flat with tax", fees[4], "50", FeeTierNormal, true, "40.5"},
+		// 1 - 5% WinMiner fee - 2 flat provider fee.
+		{"fees exceed gross", fees[1], "1", FeeTierHigh, true, "-1.05"},
+	}
+
+	for _, test := range tests {
+		net, err := test.fee.NetPayout(decimal.RequireFromString(test.gross), test.tier, test.fixed)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if want := decimal.RequireFromString(test.net); !net.Equal(want) {
+			t.Errorf("%s: expected net %s, got %s", test.name, want, net)
+		}
+	}
+}
+
+func TestNetPayoutUnknownTier(t *testing.T) {
+	fee := FeeEntry{ProviderFee: decimal.New(1, 0)}
+
+	for _, tier := range []FeeTier{"", "Normal", "LOW", "medium"} {
+		_, err := fee.NetPayout(decimal.New(100, 0), tier, true)
+		if err == nil {
+			t.Errorf("expected tier %q to be rejected", tier)
+		}
+		if _, ok := fee.ProviderFeeForTier(tier); ok {
+			t.Errorf("expected tier %q to be unknown", tier)
+		}
+	}
+}